@@ -0,0 +1,278 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"telegram-listener/database"
+	"telegram-listener/listener"
+	"telegram-listener/reaction"
+	"telegram-listener/serv"
+	"telegram-listener/settings"
+
+	"gorm.io/gorm"
+)
+
+// Service is the admin JSON API on /admin/, protected by a bearer token.
+type Service struct {
+	token           string
+	dbService       *database.Service
+	listenerService *listener.Service
+	reactionService *reaction.Service
+	settingsService *settings.Service
+}
+
+func NewService(token string, dbService *database.Service, httpService *serv.Service, listenerService *listener.Service, reactionService *reaction.Service, settingsService *settings.Service) (s *Service, err error) {
+	s = &Service{
+		token:           token,
+		dbService:       dbService,
+		listenerService: listenerService,
+		reactionService: reactionService,
+		settingsService: settingsService,
+	}
+
+	if token == "" {
+		log.Println("ADMIN_TOKEN is empty, admin API disabled")
+		return
+	}
+
+	httpService.Handle("GET /admin/bots", s.auth(s.bots))
+	httpService.Handle("POST /admin/reload/{subsystem}", s.auth(s.reload))
+	httpService.Handle("POST /admin/preview", s.auth(s.preview))
+
+	httpService.Handle("GET /admin/reactions", s.auth(s.listReactions))
+	httpService.Handle("GET /admin/reactions/{id}", s.auth(s.getReaction))
+	httpService.Handle("POST /admin/reactions", s.auth(s.saveReaction))
+	httpService.Handle("PUT /admin/reactions/{id}", s.auth(s.saveReaction))
+	httpService.Handle("DELETE /admin/reactions/{id}", s.auth(s.deleteReaction))
+
+	httpService.Handle("GET /admin/settings", s.auth(s.listSettings))
+	httpService.Handle("GET /admin/settings/{id}", s.auth(s.getSetting))
+	httpService.Handle("POST /admin/settings", s.auth(s.saveSetting))
+	httpService.Handle("PUT /admin/settings/{id}", s.auth(s.saveSetting))
+	httpService.Handle("DELETE /admin/settings/{id}", s.auth(s.deleteSetting))
+
+	return
+}
+
+func (s *Service) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, req)
+	})
+}
+
+func (s *Service) bots(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"bots":   s.listenerService.Status(),
+		"events": s.listenerService.Events(),
+	})
+}
+
+func (s *Service) reload(w http.ResponseWriter, req *http.Request) {
+	var err error
+	switch req.PathValue("subsystem") {
+	case "bots":
+		err = s.listenerService.Reload()
+	case "reactions":
+		err = s.reactionService.Reload()
+	case "settings":
+		err = s.settingsService.Reload()
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown subsystem"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+type previewRequest struct {
+	BotID  int    `json:"bot_id"`
+	Text   string `json:"text"`
+	State  string `json:"state"`  // conversation state of the user, optional
+	Locale string `json:"locale"` // language of the Telegram app of the user, optional
+}
+
+func (s *Service) preview(w http.ResponseWriter, req *http.Request) {
+	request := previewRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	bot := database.TelegramBot{}
+	if err := bot.Load(s.dbService, request.BotID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.reactionService.Preview(bot, request.State, request.Locale, request.Text))
+}
+
+func (s *Service) listReactions(w http.ResponseWriter, req *http.Request) {
+	reactions, err := database.LoadBotReactions(s.dbService, queryInt(req, "bot_id"))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reactions)
+}
+
+func (s *Service) getReaction(w http.ResponseWriter, req *http.Request) {
+	reaction := &database.TelegramBotReaction{}
+	if err := reaction.Load(s.dbService, pathInt(req, "id")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reaction)
+}
+
+// saveReaction creates on POST and replaces on PUT, then reloads reactions
+// so the change is live right away.
+func (s *Service) saveReaction(w http.ResponseWriter, req *http.Request) {
+	reaction := &database.TelegramBotReaction{}
+	if err := json.NewDecoder(req.Body).Decode(reaction); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	reaction.ID = 0
+	if req.Method == http.MethodPut {
+		existing := &database.TelegramBotReaction{}
+		if err := existing.Load(s.dbService, pathInt(req, "id")); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		reaction.ID = existing.ID
+	}
+	if reaction.BotID == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("bot_id is required"))
+		return
+	}
+	if err := s.reactionService.Validate(reaction); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := reaction.Save(s.dbService); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	s.reloadAfterWrite(s.reactionService.Reload)
+	writeJSON(w, http.StatusOK, reaction)
+}
+
+func (s *Service) deleteReaction(w http.ResponseWriter, req *http.Request) {
+	reaction := &database.TelegramBotReaction{}
+	if err := reaction.Load(s.dbService, pathInt(req, "id")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := reaction.Delete(s.dbService); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	s.reloadAfterWrite(s.reactionService.Reload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) listSettings(w http.ResponseWriter, req *http.Request) {
+	settings, err := database.LoadBotSettings(s.dbService, queryInt(req, "bot_id"))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func (s *Service) getSetting(w http.ResponseWriter, req *http.Request) {
+	setting := &database.Setting{}
+	if err := setting.Load(s.dbService, pathInt(req, "id")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, setting)
+}
+
+func (s *Service) saveSetting(w http.ResponseWriter, req *http.Request) {
+	setting := &database.Setting{}
+	if err := json.NewDecoder(req.Body).Decode(setting); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	setting.ID = 0
+	if req.Method == http.MethodPut {
+		existing := &database.Setting{}
+		if err := existing.Load(s.dbService, pathInt(req, "id")); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		setting.ID = existing.ID
+	}
+	if setting.BotID == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("bot_id is required"))
+		return
+	}
+	if err := setting.Save(s.dbService); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	s.reloadAfterWrite(s.settingsService.Reload)
+	writeJSON(w, http.StatusOK, setting)
+}
+
+func (s *Service) deleteSetting(w http.ResponseWriter, req *http.Request) {
+	setting := &database.Setting{}
+	if err := setting.Load(s.dbService, pathInt(req, "id")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := setting.Delete(s.dbService); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	s.reloadAfterWrite(s.settingsService.Reload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadAfterWrite only logs, the periodic reload picks the change up anyway.
+func (s *Service) reloadAfterWrite(reload func() error) {
+	if err := reload(); err != nil {
+		log.Println("admin: reload after write failed:", err)
+	}
+}
+
+func pathInt(req *http.Request, name string) int {
+	id, _ := strconv.Atoi(req.PathValue(name))
+	return id
+}
+
+func queryInt(req *http.Request, name string) int {
+	id, _ := strconv.Atoi(req.URL.Query().Get(name))
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,135 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"telegram-listener/database"
+	"time"
+)
+
+const (
+	EventCommand      = "command"
+	EventSearch       = "search"
+	EventSearchMiss   = "search_miss"
+	EventButtonClick  = "button_click"
+	EventReaction     = "reaction" // text answered by a reaction
+	EventPushDelivery = "push_delivery"
+)
+
+const (
+	SinkGA      = "ga"
+	SinkJSONL   = "jsonl"
+	SinkMySQL   = "mysql"
+	SinkWebhook = "webhook"
+)
+
+// Event is one entry of the raw event stream.
+type Event struct {
+	Time       time.Time `json:"time"`
+	BotID      int       `json:"bot_id"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Command    string    `json:"command,omitempty"`
+	Query      string    `json:"query,omitempty"`
+	Results    int       `json:"results"`
+	ReactionID int       `json:"reaction_id,omitempty"`
+	Data       string    `json:"data,omitempty"` // callback data of a button
+	PushID     int       `json:"push_id,omitempty"`
+	LatencyMs  int64     `json:"latency_ms"`
+}
+
+// Sink receives the events of the bots that chose it. Track must not block
+// for long, sinks batch and send in the background.
+type Sink interface {
+	Track(bot database.TelegramBot, event Event)
+	Shutdown(ctx context.Context) error
+}
+
+type Config struct {
+	GAEndpoint string // GA4 Measurement Protocol URL, empty for google-analytics.com
+	GADebug    bool   // send to the validation endpoint
+	JSONLPath  string // file to append events to, "-" for stdout, empty disables the sink
+	WebhookURL string // URL to POST batches of events to, empty disables the sink
+}
+
+// Service routes events of every bot to the sinks in TelegramBot.Analytics.
+type Service struct {
+	sinks  map[string]Sink
+	warned sync.Map // bot ID and sink name -> true, unknown sinks are logged once
+}
+
+func NewService(dbService *database.Service, config Config) (s *Service, err error) {
+	s = &Service{sinks: make(map[string]Sink)}
+
+	if s.sinks[SinkGA], err = newGASink(config.GAEndpoint, config.GADebug); err != nil {
+		return nil, err
+	}
+	s.sinks[SinkMySQL] = newMySQLSink(dbService)
+	if config.JSONLPath != "" {
+		if s.sinks[SinkJSONL], err = newJSONLSink(config.JSONLPath); err != nil {
+			return nil, err
+		}
+	}
+	if config.WebhookURL != "" {
+		s.sinks[SinkWebhook] = newWebhookSink(config.WebhookURL)
+	}
+	return
+}
+
+// Sinks returns the sink names of the bot, ga when it has none.
+func Sinks(bot database.TelegramBot) (sinks []string) {
+	for _, name := range strings.Split(bot.Analytics, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			sinks = append(sinks, name)
+		}
+	}
+	if len(sinks) == 0 {
+		sinks = []string{SinkGA}
+	}
+	return
+}
+
+// Enabled reports whether any sink of the bot is configured.
+func (s *Service) Enabled(bot database.TelegramBot) bool {
+	for _, name := range Sinks(bot) {
+		if name == SinkGA && (bot.GaTrackingID == "" || bot.GaSecret == "") {
+			continue
+		}
+		if _, found := s.sinks[name]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) Track(bot database.TelegramBot, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	event.BotID = bot.ID
+	for _, name := range Sinks(bot) {
+		sink, found := s.sinks[name]
+		if !found {
+			if _, warned := s.warned.LoadOrStore(fmt.Sprintf("%d:%s", bot.ID, name), true); !warned {
+				log.Printf("analytics: bot %d uses unknown or disabled sink %q", bot.ID, name)
+			}
+			continue
+		}
+		sink.Track(bot, event)
+	}
+}
+
+// Shutdown sends what the sinks have queued.
+func (s *Service) Shutdown(ctx context.Context) error {
+	var errs []error
+	for name, sink := range s.sinks {
+		if err := sink.Shutdown(ctx); err != nil {
+			errs = append(errs, errors.New(name+": "+err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"telegram-listener/batching"
+	"telegram-listener/metrics"
+	"time"
+)
+
+const (
+	batchSize   = 500   // events in one write
+	maxQueue    = 10000 // the oldest events go first when a sink is that far behind
+	flushPeriod = 5 * time.Second
+)
+
+// batcher collects events and hands them to write in batches of batchSize.
+type batcher struct {
+	name  string
+	write func(events []Event) error
+
+	mu     sync.Mutex
+	events []Event
+	loop   *batching.Loop
+}
+
+func newBatcher(name string, write func(events []Event) error) *batcher {
+	b := &batcher{
+		name:  name,
+		write: write,
+	}
+	b.loop = batching.NewLoop(flushPeriod, b.flush)
+	return b
+}
+
+func (b *batcher) add(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.loop.Closed() {
+		return
+	}
+	if len(b.events) >= maxQueue {
+		b.events = b.events[1:]
+		metrics.AnalyticsEvents.WithLabelValues(b.name, "dropped").Inc()
+	}
+	b.events = append(b.events, event)
+	if len(b.events) >= batchSize {
+		b.loop.Kick()
+	}
+}
+
+func (b *batcher) flush(final bool) {
+	for {
+		b.mu.Lock()
+		n := min(len(b.events), batchSize)
+		events := b.events[:n:n]
+		b.events = b.events[n:]
+		b.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		result := "sent"
+		if err := b.write(events); err != nil {
+			log.Printf("analytics %s: %v", b.name, err)
+			result = "failed"
+		}
+		metrics.AnalyticsEvents.WithLabelValues(b.name, result).Add(float64(n))
+	}
+}
+
+// shutdown writes the rest of the events.
+func (b *batcher) shutdown(ctx context.Context) error {
+	return b.loop.Shutdown(ctx)
+}
@@ -0,0 +1,46 @@
+package analytics
+
+import (
+	"context"
+	"strconv"
+	"telegram-listener/database"
+	"telegram-listener/ga"
+)
+
+// gaSink forwards events to the GA4 stream of the bot (GaTrackingID and
+// GaSecret), GA gets the event names it had before the raw stream.
+type gaSink struct {
+	gaService *ga.Service
+}
+
+func newGASink(endpoint string, debug bool) (*gaSink, error) {
+	gaService, err := ga.NewService(endpoint, debug)
+	if err != nil {
+		return nil, err
+	}
+	return &gaSink{gaService: gaService}, nil
+}
+
+func (sink *gaSink) Track(bot database.TelegramBot, event Event) {
+	var gaEvent ga.Event
+	switch event.Name {
+	case EventCommand:
+		gaEvent = ga.Command(event.Command)
+	case EventSearch:
+		gaEvent = ga.Search(event.Query, event.Results)
+	case EventSearchMiss:
+		gaEvent = ga.SearchMiss(event.Query)
+	case EventButtonClick:
+		gaEvent = ga.ButtonClick(event.Data)
+	case EventPushDelivery:
+		gaEvent = ga.PushDelivery(event.PushID)
+	default:
+		return
+	}
+	stream := ga.Stream{MeasurementID: bot.GaTrackingID, Secret: bot.GaSecret}
+	sink.gaService.Track(bot.ID, stream, strconv.FormatInt(event.UserID, 10), gaEvent)
+}
+
+func (sink *gaSink) Shutdown(ctx context.Context) error {
+	return sink.gaService.Shutdown(ctx)
+}
@@ -0,0 +1,52 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"telegram-listener/database"
+)
+
+// jsonlSink writes events as JSON lines to a file or stdout.
+type jsonlSink struct {
+	batcher *batcher
+	out     io.WriteCloser
+}
+
+func newJSONLSink(path string) (*jsonlSink, error) {
+	sink := &jsonlSink{out: os.Stdout}
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		sink.out = file
+	}
+	sink.batcher = newBatcher(SinkJSONL, sink.write)
+	return sink, nil
+}
+
+func (sink *jsonlSink) write(events []Event) error {
+	w := bufio.NewWriter(sink.out)
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (sink *jsonlSink) Track(bot database.TelegramBot, event Event) {
+	sink.batcher.add(event)
+}
+
+func (sink *jsonlSink) Shutdown(ctx context.Context) error {
+	err := sink.batcher.shutdown(ctx)
+	if sink.out != os.Stdout {
+		sink.out.Close()
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"context"
+	"telegram-listener/database"
+)
+
+// mysqlSink inserts events into telegram_event in batches.
+type mysqlSink struct {
+	batcher *batcher
+}
+
+func newMySQLSink(dbService *database.Service) *mysqlSink {
+	return &mysqlSink{batcher: newBatcher(SinkMySQL, func(events []Event) error {
+		rows := make([]*database.TelegramEvent, 0, len(events))
+		for _, event := range events {
+			rows = append(rows, &database.TelegramEvent{
+				BotID:      event.BotID,
+				UserID:     event.UserID,
+				Name:       event.Name,
+				Command:    event.Command,
+				Query:      event.Query,
+				Results:    event.Results,
+				ReactionID: event.ReactionID,
+				PushID:     event.PushID,
+				Data:       event.Data,
+				LatencyMs:  event.LatencyMs,
+				CreatedAt:  event.Time,
+			})
+		}
+		return database.SaveEvents(dbService, rows)
+	})}
+}
+
+func (sink *mysqlSink) Track(bot database.TelegramBot, event Event) {
+	sink.batcher.add(event)
+}
+
+func (sink *mysqlSink) Shutdown(ctx context.Context) error {
+	return sink.batcher.shutdown(ctx)
+}
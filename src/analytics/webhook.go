@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"telegram-listener/database"
+	"time"
+)
+
+// webhookSink POSTs batches of events as a JSON array to a URL.
+type webhookSink struct {
+	batcher *batcher
+	client  *http.Client
+	url     string
+}
+
+func newWebhookSink(url string) *webhookSink {
+	sink := &webhookSink{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    url,
+	}
+	sink.batcher = newBatcher(SinkWebhook, sink.write)
+	return sink
+}
+
+func (sink *webhookSink) write(events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	resp, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", sink.url, resp.Status)
+	}
+	return nil
+}
+
+func (sink *webhookSink) Track(bot database.TelegramBot, event Event) {
+	sink.batcher.add(event)
+}
+
+func (sink *webhookSink) Shutdown(ctx context.Context) error {
+	return sink.batcher.shutdown(ctx)
+}
@@ -0,0 +1,81 @@
+// Package batching runs the write-behind loops of the queues that store or
+// send in batches: users, analytics sinks and GA.
+package batching
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Loop calls flush from a single goroutine every period, when kicked and a
+// last time after Shutdown. It runs until Shutdown, not until the context of
+// the app is done, so what is queued while the app stops is flushed too.
+//
+// Queues check Closed under their own lock before adding and flush takes
+// from them under it, so nothing added is left behind by the last flush.
+type Loop struct {
+	period time.Duration
+	flush  func(final bool)
+
+	mu     sync.Mutex
+	closed bool
+	kick   chan struct{}
+	done   chan struct{}
+}
+
+func NewLoop(period time.Duration, flush func(final bool)) *Loop {
+	l := &Loop{
+		period: period,
+		flush:  flush,
+		kick:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Kick flushes right away, e.g. when a full batch is waiting.
+func (l *Loop) Kick() {
+	select {
+	case l.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Closed is true after Shutdown.
+func (l *Loop) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// Shutdown makes the last flush and waits for it until ctx is done.
+func (l *Loop) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.Kick()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Loop) run() {
+	defer close(l.done)
+	for {
+		select {
+		case <-l.kick:
+		case <-time.After(l.period):
+		}
+		final := l.Closed()
+		l.flush(final)
+		if final {
+			return
+		}
+	}
+}
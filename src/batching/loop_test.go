@@ -0,0 +1,110 @@
+package batching
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queue is a queue the way the users of Loop build it.
+type queue struct {
+	mu      sync.Mutex
+	items   []int
+	flushed []int
+	finals  int
+	loop    *Loop
+}
+
+func newQueue(period time.Duration) *queue {
+	q := &queue{}
+	q.loop = NewLoop(period, q.flush)
+	return q
+}
+
+func (q *queue) add(item int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.loop.Closed() {
+		return false
+	}
+	q.items = append(q.items, item)
+	return true
+}
+
+func (q *queue) flush(final bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flushed = append(q.flushed, q.items...)
+	q.items = nil
+	if final {
+		q.finals++
+	}
+}
+
+func (q *queue) flushedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.flushed)
+}
+
+func shutdown(t *testing.T, l *Loop) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestLoopFlushesEveryPeriod(t *testing.T) {
+	q := newQueue(10 * time.Millisecond)
+	defer shutdown(t, q.loop)
+	q.add(1)
+	deadline := time.Now().Add(time.Second)
+	for q.flushedCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing flushed after the period")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoopKick(t *testing.T) {
+	q := newQueue(time.Hour)
+	defer shutdown(t, q.loop)
+	q.add(1)
+	q.loop.Kick()
+	deadline := time.Now().Add(time.Second)
+	for q.flushedCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("kick did not flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoopShutdownFlushesOnce(t *testing.T) {
+	q := newQueue(time.Hour)
+	for i := 0; i < 3; i++ {
+		q.add(i)
+	}
+	shutdown(t, q.loop)
+	if q.flushedCount() != 3 || q.finals != 1 {
+		t.Fatalf("flushed %d items in %d final flushes, want 3 in 1", q.flushedCount(), q.finals)
+	}
+	if q.add(4) {
+		t.Fatal("added after Shutdown")
+	}
+	shutdown(t, q.loop) // a second Shutdown returns at once
+}
+
+func TestLoopShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	l := NewLoop(time.Hour, func(final bool) { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want the deadline", err)
+	}
+}
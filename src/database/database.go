@@ -32,3 +32,11 @@ func NewService(mysqlURL string) (s *Service, err error) {
 
 	return
 }
+
+func (s *Service) Close() error {
+	db, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -8,6 +8,7 @@ type TelegramBot struct {
 	ID           int
 	Name         string
 	ListenURL    string
+	Mode         string // "webhook" takes updates on ListenURL, anything else long polls
 	Type         string
 	BotURL       string
 	AppURL       string
@@ -16,6 +17,7 @@ type TelegramBot struct {
 	GaTrackingID string
 	GaSecret     string
 	SearchURL    string
+	Analytics    string // comma separated sinks of events: ga, jsonl, mysql, webhook; empty for ga
 	UpdatedAt    string
 	Published    bool
 }
@@ -5,14 +5,26 @@ import (
 )
 
 type TelegramBotReaction struct {
-	ID                  int
-	BotID               int
-	AdditionalMessageID int
-	Handle              string
-	Answer              string
-	InlineMenu          string
-	ReplyMenu           string
-	Published           bool
+	ID                  int     `json:"id"`
+	BotID               int     `json:"bot_id"`
+	AdditionalMessageID int     `json:"additional_message_id"`
+	Handle              string  `json:"handle"`
+	Answer              string  `json:"answer"`
+	InlineMenu          string  `json:"inline_menu"`
+	ReplyMenu           string  `json:"reply_menu"`
+	Published           bool    `json:"published"`
+	MatchType           string  `json:"match_type"`      // how Handle matches text, see reaction.Match*
+	MatchThreshold      float64 `json:"match_threshold"` // similarity 0..1 for fuzzy matching
+	Priority            int     `json:"priority"`        // higher wins when several reactions match
+	IsSearch            bool    `json:"is_search"`       // Handle is the search API of the bot
+	State               string  `json:"state"`           // conversation state the reaction answers in, empty for the default one
+	NextState           string  `json:"next_state"`      // state the user moves to, empty resets the conversation
+	StateTimeout        int     `json:"state_timeout"`   // seconds before NextState resets, 0 for 30 minutes
+	Type                string  `json:"type"`            // "text" (default), "photo" or "video"
+	MediaURL            string  `json:"media_url"`       // photo or video, Answer is the caption
+	Delay               int     `json:"delay"`           // milliseconds to wait before sending
+	ChatAction          string  `json:"chat_action"`     // shown during Delay, e.g. "typing" or "upload_photo"
+	Locale              string  `json:"locale"`          // variant of the reaction with the same Handle for "pt-BR" or "pt", empty for the bot default
 }
 
 func (c *TelegramBotReaction) TableName() string {
@@ -30,3 +42,26 @@ func LoadReactions(dbService *Service) (reactions []*TelegramBotReaction, err er
 	}
 	return
 }
+
+// LoadBotReactions returns published and unpublished reactions of the bot,
+// of all bots for botID 0.
+func LoadBotReactions(dbService *Service, botID int) (reactions []*TelegramBotReaction, err error) {
+	tx := dbService.DB.Model(&TelegramBotReaction{})
+	if botID > 0 {
+		tx = tx.Where("bot_id=?", botID)
+	}
+	err = tx.Order("id").Find(&reactions).Error
+	return
+}
+
+func (c *TelegramBotReaction) Load(dbService *Service, id int) (err error) {
+	return dbService.DB.Where("id=?", id).Limit(1).First(&c).Error
+}
+
+func (c *TelegramBotReaction) Save(dbService *Service) (err error) {
+	return dbService.DB.Save(c).Error
+}
+
+func (c *TelegramBotReaction) Delete(dbService *Service) (err error) {
+	return dbService.DB.Delete(c).Error
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"time"
+)
+
+// TelegramEvent is a row of the raw analytics event stream.
+type TelegramEvent struct {
+	ID         int64
+	BotID      int
+	UserID     int64
+	Name       string
+	Command    string
+	Query      string
+	Results    int
+	ReactionID int
+	PushID     int
+	Data       string
+	LatencyMs  int64
+	CreatedAt  time.Time
+}
+
+func (c *TelegramEvent) TableName() string {
+	return "telegram_event"
+}
+
+func SaveEvents(dbService *Service, events []*TelegramEvent) (err error) {
+	if len(events) == 0 {
+		return nil
+	}
+	return dbService.DB.CreateInBatches(events, 500).Error
+}
@@ -8,7 +8,8 @@ type TelegramPush struct {
 	ID            int
 	Type          string // "text" or "photo"
 	BotID         int
-	StartedAt     *time.Time
+	StartedAt     *time.Time // planned start, the push is sent after it
+	ClaimedAt     *time.Time // when a worker took the push
 	EndAt         *time.Time
 	AudienceID    int
 	Affected      int
@@ -36,6 +37,25 @@ func (c *TelegramPush) SearchTasks(dbService *Service) (err error) {
 	return dbService.DB.Where("started_at<NOW() AND status='ready'").Limit(1).Find(&c).Error
 }
 
+// Claim atomically moves a ready push to started, so only one worker picks it
+// up. The planned started_at is kept, the claim time goes to claimed_at.
+func (c *TelegramPush) Claim(dbService *Service) (ok bool, err error) {
+	now := time.Now().UTC()
+	res := dbService.DB.Model(&TelegramPush{}).Where("id=? AND status='ready'", c.ID).Updates(map[string]interface{}{
+		"status":     "started",
+		"claimed_at": now,
+	})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	c.Status = "started"
+	c.ClaimedAt = &now
+	return true, nil
+}
+
 func (c *TelegramPush) SetStatusStart(dbService *Service) (err error) {
 	c.Status = "started"
 	now := time.Now().UTC()
@@ -43,6 +63,13 @@ func (c *TelegramPush) SetStatusStart(dbService *Service) (err error) {
 	return c.save(dbService)
 }
 
+// SetStatusReady hands an interrupted push back to the queue. Users that
+// already got it are skipped on the next run by their push_id.
+func (c *TelegramPush) SetStatusReady(dbService *Service) (err error) {
+	c.Status = "ready"
+	return c.save(dbService)
+}
+
 func (c *TelegramPush) SetStatusError(dbService *Service) (err error) {
 	c.Status = "error"
 	now := time.Now().UTC()
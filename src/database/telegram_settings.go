@@ -1,15 +1,16 @@
 package database
 
 type Setting struct {
-	ID        int
-	BotID     int
-	Command   string
-	Part      string
-	Orderby   int
-	Content   string
-	ImageURL  string
-	Link      string
-	Published bool
+	ID        int    `json:"id"`
+	BotID     int    `json:"bot_id"`
+	Command   string `json:"command"`
+	Part      string `json:"part"`
+	Orderby   int    `json:"orderby"`
+	Content   string `json:"content"`
+	ImageURL  string `json:"image_url"`
+	Link      string `json:"link"`
+	Published bool   `json:"published"`
+	Locale    string `json:"locale"` // e.g. "pt-BR" or "pt", empty for the bot default
 }
 
 func (c *Setting) TableName() string {
@@ -20,3 +21,26 @@ func GetAllSettings(dbService *Service) (settings []*Setting, err error) {
 	err = dbService.DB.Where("published=1").Order("command,part,orderby").Find(&settings).Error
 	return
 }
+
+// LoadBotSettings returns published and unpublished settings of the bot,
+// of all bots for botID 0.
+func LoadBotSettings(dbService *Service, botID int) (settings []*Setting, err error) {
+	tx := dbService.DB.Model(&Setting{})
+	if botID > 0 {
+		tx = tx.Where("bot_id=?", botID)
+	}
+	err = tx.Order("command,part,orderby").Find(&settings).Error
+	return
+}
+
+func (c *Setting) Load(dbService *Service, id int) (err error) {
+	return dbService.DB.Where("id=?", id).Limit(1).First(&c).Error
+}
+
+func (c *Setting) Save(dbService *Service) (err error) {
+	return dbService.DB.Save(c).Error
+}
+
+func (c *Setting) Delete(dbService *Service) (err error) {
+	return dbService.DB.Delete(c).Error
+}
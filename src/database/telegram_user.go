@@ -3,9 +3,18 @@ package database
 import (
 	"time"
 
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+const ChatTypePrivate = "private"
+
+// Reasons of TelegramUser.Disabled.
+const (
+	DisabledBlocked     = "blocked"     // the user blocked the bot
+	DisabledDeactivated = "deactivated" // the account of the user was deleted
+)
+
 type TelegramUser struct {
 	ID             int
 	BotID          int
@@ -17,29 +26,147 @@ type TelegramUser struct {
 	PushID         int
 	Disabled       bool
 	PushTime       *time.Time
+	State          string     // conversation state, empty is the default one
+	StateData      string     // JSON of the answers given in the states of the conversation
+	StateUntil     *time.Time // the state resets to the default one after
+	Language       string     // chosen by /language, empty to follow the Telegram app
+	Username       string
+	FirstName      string
+	LastName       string
+	LanguageCode   string // of the Telegram app
+	IsPremium      bool
+	ChatType       string     // private, group, supergroup or channel of the last update with a chat
+	DisabledAt     *time.Time // when the user last blocked the bot
+	DisabledReason string     // DisabledBlocked or DisabledDeactivated, empty while enabled
 }
 
 func (c *TelegramUser) TableName() string {
 	return "telegram_user"
 }
 
-func UpdateUsersPushID(dbService *Service, userIds []int64, pushID int) (err error) {
+func UpdateUsersPushID(dbService *Service, botID int, userIds []int64, pushID int) (err error) {
 	if len(userIds) == 0 {
 		return nil
 	}
-	return dbService.DB.Model(&TelegramUser{}).Where("tg_id IN (?)", userIds).Update("push_id", pushID).Error
+	return dbService.DB.Model(&TelegramUser{}).Where("bot_id=? AND tg_id IN (?)", botID, userIds).Updates(map[string]interface{}{
+		"push_id":   pushID,
+		"push_time": time.Now().UTC(),
+	}).Error
+}
+
+// LoadPushAudience returns the next batch of enabled users of the bot matching
+// the audience query (a WHERE condition on telegram_user) that have not got
+// the push yet.
+func LoadPushAudience(dbService *Service, botID int, query string, pushID int, afterID int, limit int) (users []TelegramUser, err error) {
+	tx := dbService.DB.Where("bot_id=? AND disabled=0 AND push_id!=? AND id>?", botID, pushID, afterID)
+	if query != "" {
+		tx = tx.Where(query)
+	}
+	err = tx.Order("id").Limit(limit).Find(&users).Error
+	return
 }
 
-func UpsertUser(dbService *Service, botID int, tgID int64, lastCommand string) (err error) {
-	user := &TelegramUser{
-		BotID:       botID,
-		TgID:        tgID,
-		LastCommand: lastCommand,
+// UpsertUsers stores the users with their profile and activity in one
+// multi-row query. Counter of a user is the number of its updates since the
+// last upsert and is added to the stored one; ChatType is kept when a user
+// has none. enable clears Disabled, the users wrote to the bot in private,
+// unless they blocked it after their last activity in the batch.
+func UpsertUsers(dbService *Service, users []*TelegramUser, enable bool) (err error) {
+	if len(users) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for _, user := range users {
+		user.UpdatedAt = &now
+		if user.LastActivityAt == nil {
+			user.LastActivityAt = &now
+		}
+	}
+
+	updates := append(
+		clause.AssignmentColumns([]string{"last_command", "updated_at", "last_activity_at", "username", "first_name", "last_name", "language_code", "is_premium"}),
+		clause.Assignment{Column: clause.Column{Name: "counter"}, Value: gorm.Expr("counter + VALUES(counter)")},
+		clause.Assignment{Column: clause.Column{Name: "chat_type"}, Value: gorm.Expr("IF(VALUES(chat_type)='', chat_type, VALUES(chat_type))")},
+	)
+	if enable {
+		// a block stored after the activity of the batch wins, the user blocked the bot since
+		const newer = "disabled_at IS NULL OR disabled_at < VALUES(last_activity_at)"
+		updates = append(updates,
+			clause.Assignment{Column: clause.Column{Name: "disabled"}, Value: gorm.Expr("IF(" + newer + ", 0, disabled)")},
+			clause.Assignment{Column: clause.Column{Name: "disabled_reason"}, Value: gorm.Expr("IF(" + newer + ", '', disabled_reason)")},
+		)
 	}
-	err = dbService.DB.Clauses(clause.OnConflict{
+	return dbService.DB.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "bot_id"}, {Name: "tg_id"}}, // уникальный ключ
-		DoUpdates: clause.AssignmentColumns([]string{"last_command"}), // поля для обновления
-	}).Create(user).Error
+		DoUpdates: updates,                                            // поля для обновления
+	}).Create(users).Error
+}
 
+// LoadUser returns the user of the bot, an empty user if there is none yet.
+func LoadUser(dbService *Service, botID int, tgID int64) (user *TelegramUser, err error) {
+	user = &TelegramUser{}
+	err = dbService.DB.Where("bot_id=? AND tg_id=?", botID, tgID).Limit(1).Find(user).Error
 	return
 }
+
+// ActiveState returns the conversation state of the user, empty when the
+// user is in the default state or the state timed out.
+func (user *TelegramUser) ActiveState() (state string, stateData string) {
+	if user.State == "" || user.StateUntil != nil && user.StateUntil.Before(time.Now().UTC()) {
+		return "", ""
+	}
+	return user.State, user.StateData
+}
+
+// SetUserState moves the user to state until the time, the empty state resets
+// the conversation.
+func SetUserState(dbService *Service, botID int, tgID int64, state string, stateData string, until *time.Time) (err error) {
+	user := &TelegramUser{BotID: botID, TgID: tgID, State: state, StateData: stateData, StateUntil: until}
+	return upsertColumns(dbService, user, "state", "state_data", "state_until")
+}
+
+// SetUserLanguage stores the language chosen by the user, empty to follow the
+// language of the Telegram app.
+func SetUserLanguage(dbService *Service, botID int, tgID int64, language string) (err error) {
+	return upsertColumns(dbService, &TelegramUser{BotID: botID, TgID: tgID, Language: language}, "language")
+}
+
+// upsertColumns writes the columns of the user, creating it when the upsert
+// queue has not stored it yet.
+func upsertColumns(dbService *Service, user *TelegramUser, columns ...string) (err error) {
+	return dbService.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "bot_id"}, {Name: "tg_id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(user).Error
+}
+
+// DisableUser marks the user that blocked the bot, pushes and the sender skip
+// disabled users.
+func DisableUser(dbService *Service, botID int, tgID int64, reason string) (err error) {
+	return dbService.DB.Model(&TelegramUser{}).Where("bot_id=? AND tg_id=?", botID, tgID).Updates(disabled(reason)).Error
+}
+
+// DisableUserOfToken is DisableUser for callers that only know the token of
+// the bot.
+func DisableUserOfToken(dbService *Service, token string, tgID int64, reason string) (err error) {
+	return dbService.DB.Model(&TelegramUser{}).
+		Where("bot_id=(SELECT id FROM telegram_bot WHERE token=? LIMIT 1) AND tg_id=?", token, tgID).
+		Updates(disabled(reason)).Error
+}
+
+func disabled(reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"disabled":        true,
+		"disabled_at":     time.Now().UTC(),
+		"disabled_reason": reason,
+	}
+}
+
+// EnableUser clears Disabled of the user that unblocked the bot, DisabledAt
+// keeps the time of the last block.
+func EnableUser(dbService *Service, botID int, tgID int64) (err error) {
+	return dbService.DB.Model(&TelegramUser{}).Where("bot_id=? AND tg_id=?", botID, tgID).Updates(map[string]interface{}{
+		"disabled":        false,
+		"disabled_reason": "",
+	}).Error
+}
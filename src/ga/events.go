@@ -0,0 +1,32 @@
+package ga
+
+// maxParam is the length GA4 keeps of a string parameter.
+const maxParam = 100
+
+func param(value string) string {
+	if runes := []rune(value); len(runes) > maxParam {
+		return string(runes[:maxParam])
+	}
+	return value
+}
+
+func Command(command string) Event {
+	return Event{Name: "command", Params: map[string]interface{}{"command": param(command)}}
+}
+
+// Search is the recommended GA4 search event with the number of results.
+func Search(query string, results int) Event {
+	return Event{Name: "search", Params: map[string]interface{}{"search_term": param(query), "results": results}}
+}
+
+func SearchMiss(query string) Event {
+	return Event{Name: "search_miss", Params: map[string]interface{}{"search_term": param(query)}}
+}
+
+func ButtonClick(data string) Event {
+	return Event{Name: "button_click", Params: map[string]interface{}{"button": param(data)}}
+}
+
+func PushDelivery(pushID int) Event {
+	return Event{Name: "push_delivery", Params: map[string]interface{}{"push_id": pushID}}
+}
@@ -2,57 +2,260 @@ package ga
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"telegram-listener/batching"
+	"telegram-listener/metrics"
+	"time"
 )
 
-func SendEvent(gaTrackingID, gaSecret, userID, command, parameter string) {
-	// async send event
-	go sendEvent(gaTrackingID, gaSecret, userID, command, parameter)
+const (
+	DefaultEndpoint = "https://www.google-analytics.com/mp/collect"
+
+	maxBatch    = 25               // events GA4 takes in one request
+	maxQueue    = 1000             // per bot, its oldest events go first
+	maxBatches  = 100              // batches waiting for a worker, the flusher waits for a free one
+	workers     = 4                // requests to GA at the same time
+	flushPeriod = 5 * time.Second  // events of a user in this window go in one request
+	sendTimeout = 10 * time.Second // of one request
+)
+
+// Stream is the GA4 data stream of a bot, TelegramBot.GaTrackingID and GaSecret.
+type Stream struct {
+	MeasurementID string
+	Secret        string
+}
+
+func (stream Stream) Enabled() bool {
+	return stream.MeasurementID != "" && stream.Secret != ""
+}
+
+type Event struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
-func sendEvent(gaTrackingID, gaSecret, userID, command, parameter string) {
+type pending struct {
+	clientID string
+	event    Event
+}
 
-	// Данные события
-	event := map[string]interface{}{
-		"name": "telegram-bot",
-		"params": map[string]interface{}{
-			"command":   command,
-			"parameter": parameter,
-		},
+type queue struct {
+	stream Stream
+	events []pending
+}
+
+type batch struct {
+	stream   Stream
+	clientID string
+	events   []Event
+}
+
+// Service sends Measurement Protocol events in batches. Events are queued
+// per bot and flushed every flushPeriod or when a bot has maxBatch of them,
+// a fixed pool of workers sends the batches. When GA is slow the flusher
+// waits for the workers and the queues fill up to maxQueue, Track never
+// blocks.
+type Service struct {
+	mu       sync.Mutex
+	queues   map[int]*queue // bot ID -> events
+	loop     *batching.Loop
+	batches  chan batch
+	done     sync.WaitGroup
+	client   *http.Client
+	endpoint string
+	debug    bool
+}
+
+// NewService sends events to endpoint, DefaultEndpoint when empty. debug
+// switches to the validation endpoint (/debug/mp/collect), which checks the
+// events without recording them and logs what is wrong with them.
+func NewService(endpoint string, debug bool) (s *Service, err error) {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if debug {
+		endpoint = strings.Replace(endpoint, "/mp/collect", "/debug/mp/collect", 1)
+	}
+	if _, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("ga endpoint: %w", err)
+	}
+	s = &Service{
+		queues:   make(map[int]*queue),
+		batches:  make(chan batch, maxBatches),
+		client:   &http.Client{Timeout: sendTimeout},
+		endpoint: endpoint,
+		debug:    debug,
 	}
 
-	// Сформируйте запрос
-	data := map[string]interface{}{
-		"client_id": userID,
-		"events":    []map[string]interface{}{event},
+	for i := 0; i < workers; i++ {
+		s.done.Add(1)
+		go s.worker()
 	}
+	s.loop = batching.NewLoop(flushPeriod, s.flush)
 
-	// Кодирование данных в JSON
-	dataJSON, err := json.Marshal(data)
-	if err != nil {
-		log.Println("Ошибка кодирования данных:", err)
+	return
+}
+
+// Track queues the event of the user (client) of the bot.
+func (s *Service) Track(botID int, stream Stream, clientID string, event Event) {
+	if !stream.Enabled() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loop.Closed() {
 		return
 	}
+	q := s.queues[botID]
+	if q == nil {
+		q = &queue{}
+		s.queues[botID] = q
+	}
+	q.stream = stream
+	if len(q.events) >= maxQueue {
+		q.events = q.events[1:]
+		metrics.AnalyticsEvents.WithLabelValues("ga", "dropped").Inc()
+	}
+	q.events = append(q.events, pending{clientID: clientID, event: event})
+	if len(q.events) >= maxBatch {
+		s.loop.Kick()
+	}
+}
 
-	// Создание запроса
-	url := fmt.Sprintf("https://www.google-analytics.com/mp/collect?api_secret=%s&measurement_id=%s", gaSecret, gaTrackingID)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(dataJSON))
+// Shutdown sends the queued events and waits for the workers.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if err := s.loop.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.done.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush is the only sender to the workers, the last one lets them finish.
+func (s *Service) flush(final bool) {
+	s.mu.Lock()
+	var batches []batch
+	for botID := range s.queues {
+		batches = append(batches, s.takeLocked(botID)...)
+	}
+	s.mu.Unlock()
+	for _, b := range batches {
+		s.batches <- b
+	}
+	if final {
+		close(s.batches)
+	}
+}
+
+// takeLocked empties the queue of the bot into batches, one per user and up
+// to maxBatch events. The caller holds s.mu.
+func (s *Service) takeLocked(botID int) (batches []batch) {
+	q := s.queues[botID]
+	if q == nil || len(q.events) == 0 {
+		return nil
+	}
+	byClient := make(map[string][]Event)
+	order := []string{}
+	for _, p := range q.events {
+		if _, found := byClient[p.clientID]; !found {
+			order = append(order, p.clientID)
+		}
+		byClient[p.clientID] = append(byClient[p.clientID], p.event)
+	}
+	q.events = nil
+
+	for _, clientID := range order {
+		events := byClient[clientID]
+		for start := 0; start < len(events); start += maxBatch {
+			end := min(start+maxBatch, len(events))
+			batches = append(batches, batch{stream: q.stream, clientID: clientID, events: events[start:end]})
+		}
+	}
+	return
+}
+
+func (s *Service) worker() {
+	defer s.done.Done()
+	for b := range s.batches {
+		result := "sent"
+		if err := s.send(b); err != nil {
+			log.Println("ga:", err)
+			result = "failed"
+		}
+		metrics.AnalyticsEvents.WithLabelValues("ga", result).Add(float64(len(b.events)))
+	}
+}
+
+func (s *Service) send(b batch) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"client_id": b.clientID,
+		"user_id":   b.clientID,
+		"events":    b.events,
+	})
 	if err != nil {
-		log.Println("Ошибка создания запроса:", err)
-		return
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("measurement_id", b.stream.MeasurementID)
+	params.Set("api_secret", b.stream.Secret)
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	// Отправка запроса
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		log.Println("Ошибка выполнения запроса:", err)
-		return
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // its URL holds api_secret
+		}
+		return fmt.Errorf("%s: %w", b.stream.MeasurementID, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", b.stream.MeasurementID, resp.Status)
+	}
+	if s.debug {
+		s.logValidation(b, resp.Body)
+	}
+	return nil
+}
 
+// logValidation prints what the validation endpoint found wrong.
+func (s *Service) logValidation(b batch, body io.Reader) {
+	var validation struct {
+		ValidationMessages []struct {
+			FieldPath      string `json:"fieldPath"`
+			Description    string `json:"description"`
+			ValidationCode string `json:"validationCode"`
+		} `json:"validationMessages"`
+	}
+	if err := json.NewDecoder(body).Decode(&validation); err != nil {
+		log.Printf("ga: %s: unreadable validation answer: %v", b.stream.MeasurementID, err)
+		return
+	}
+	for _, message := range validation.ValidationMessages {
+		log.Printf("ga: %s: %s %s: %s", b.stream.MeasurementID, message.ValidationCode, message.FieldPath, message.Description)
+	}
 }
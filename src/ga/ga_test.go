@@ -0,0 +1,199 @@
+package ga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStream = Stream{MeasurementID: "G-TEST", Secret: "secret"}
+
+type request struct {
+	path     string
+	query    map[string]string
+	clientID string
+	events   []Event
+}
+
+// collector is a Measurement Protocol endpoint recording the requests.
+type collector struct {
+	mu       sync.Mutex
+	requests []request
+	received chan struct{}
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	c := &collector{received: make(chan struct{}, 1000)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ClientID string  `json:"client_id"`
+			Events   []Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("bad body: %v", err)
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, request{
+			path:     r.URL.Path,
+			query:    map[string]string{"measurement_id": r.URL.Query().Get("measurement_id"), "api_secret": r.URL.Query().Get("api_secret")},
+			clientID: body.ClientID,
+			events:   body.Events,
+		})
+		c.mu.Unlock()
+		c.received <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func (c *collector) all() []request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]request(nil), c.requests...)
+}
+
+func newTestService(t *testing.T, endpoint string, debug bool) *Service {
+	s, err := NewService(endpoint, debug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func shutdown(t *testing.T, s *Service) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		events map[string]int // client -> events tracked
+	}{
+		{name: "one client", events: map[string]int{"1": 3}},
+		{name: "more than a batch", events: map[string]int{"1": 60}},
+		{name: "clients are not mixed", events: map[string]int{"1": 30, "2": 1, "3": 25}},
+		{name: "more clients than the workers take", events: clients(3 * maxBatches)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newCollector(t)
+			s := newTestService(t, server.URL+"/mp/collect", false)
+			total := 0
+			for clientID, n := range tt.events {
+				for i := 0; i < n; i++ {
+					s.Track(1, testStream, clientID, Event{Name: "command", Params: map[string]interface{}{"n": i}})
+				}
+				total += n
+			}
+			shutdown(t, s)
+
+			got := map[string]int{}
+			for _, r := range c.all() {
+				if len(r.events) == 0 || len(r.events) > maxBatch {
+					t.Fatalf("request of %d events", len(r.events))
+				}
+				if r.query["measurement_id"] != testStream.MeasurementID || r.query["api_secret"] != testStream.Secret {
+					t.Fatalf("query = %v", r.query)
+				}
+				got[r.clientID] += len(r.events)
+			}
+			for clientID, n := range tt.events {
+				if got[clientID] != n {
+					t.Fatalf("client %s: sent %d events, want %d", clientID, got[clientID], n)
+				}
+			}
+			if len(got) != len(tt.events) {
+				t.Fatalf("sent to %d clients, want %d", len(got), len(tt.events))
+			}
+		})
+	}
+}
+
+func clients(n int) map[string]int {
+	events := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		events[fmt.Sprint(i)] = 1
+	}
+	return events
+}
+
+func TestFullBatchIsSentBeforeTheFlushPeriod(t *testing.T) {
+	c, server := newCollector(t)
+	s := newTestService(t, server.URL+"/mp/collect", false)
+	defer shutdown(t, s)
+
+	for i := 0; i < maxBatch; i++ {
+		s.Track(1, testStream, "1", Event{Name: "command"})
+	}
+	select {
+	case <-c.received:
+	case <-time.After(flushPeriod / 2):
+		t.Fatal("full batch waited for the flush period")
+	}
+}
+
+func TestDisabledStream(t *testing.T) {
+	c, server := newCollector(t)
+	s := newTestService(t, server.URL+"/mp/collect", false)
+	s.Track(1, Stream{MeasurementID: "G-TEST"}, "1", Event{Name: "command"})
+	shutdown(t, s)
+	if requests := c.all(); len(requests) != 0 {
+		t.Fatalf("sent %d requests without a secret", len(requests))
+	}
+}
+
+func TestDebugEndpoint(t *testing.T) {
+	c, server := newCollector(t)
+	s := newTestService(t, server.URL+"/mp/collect", true)
+	s.Track(1, testStream, "1", Event{Name: "command"})
+	shutdown(t, s)
+	requests := c.all()
+	if len(requests) != 1 || requests[0].path != "/debug/mp/collect" {
+		t.Fatalf("requests = %+v, want one to /debug/mp/collect", requests)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := newTestService(t, server.URL+"/mp/collect", false)
+	s.client.Timeout = 50 * time.Millisecond
+	defer shutdown(t, s)
+
+	start := time.Now()
+	err := s.send(batch{stream: testStream, clientID: "1", events: []Event{{Name: "command"}}})
+	if err == nil {
+		t.Fatal("hanging endpoint did not fail the request")
+	}
+	if strings.Contains(err.Error(), testStream.Secret) {
+		t.Fatalf("error shows the secret: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request took %v", elapsed)
+	}
+}
+
+func TestShutdownStopsTracking(t *testing.T) {
+	c, server := newCollector(t)
+	s := newTestService(t, server.URL+"/mp/collect", false)
+	shutdown(t, s)
+	s.Track(1, testStream, "1", Event{Name: "command"})
+	if requests := c.all(); len(requests) != 0 {
+		t.Fatalf("sent %d requests after Shutdown", len(requests))
+	}
+}
@@ -39,7 +39,7 @@ func SanitizeTelegramHTML(input string) string {
 					// Telegram разрешает только href у <a> и class="tg-spoiler" у <span>
 					if (n.Data == "a" && attr.Key == "href") ||
 						(n.Data == "span" && attr.Key == "class" && attr.Val == "tg-spoiler") {
-						b.WriteString(" " + attr.Key + "=\"" + attr.Val + "\"")
+						b.WriteString(" " + attr.Key + "=\"" + html.EscapeString(attr.Val) + "\"")
 					}
 				}
 				b.WriteString(">")
@@ -54,7 +54,8 @@ func SanitizeTelegramHTML(input string) string {
 				}
 			}
 		case html.TextNode:
-			b.WriteString(n.Data)
+			// Parse раскрывает сущности, экранируем обратно, иначе "<" из текста пользователя ломает разметку
+			b.WriteString(html.EscapeString(n.Data))
 		default:
 			for c := n.FirstChild; c != nil; c = c.NextSibling {
 				render(c)
@@ -0,0 +1,59 @@
+package listener
+
+import (
+	"strings"
+	"telegram-listener/analytics"
+	"telegram-listener/reaction"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// trackEvents sends commands, button clicks, reactions and searches of the bot
+// to its analytics sinks after the handler ran, searches and reactions are
+// reported by reaction in the context.
+func (flixBot *FlixBot) trackEvents(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		started := time.Now()
+		err := next(c)
+		if c.Sender() == nil {
+			return err
+		}
+		reactionID, _ := c.Get(reaction.ReactionKey).(int)
+		base := analytics.Event{
+			UserID:     c.Sender().ID,
+			ReactionID: reactionID,
+			LatencyMs:  time.Since(started).Milliseconds(),
+		}
+		track := func(event analytics.Event) {
+			flixBot.analyticsService.Track(flixBot.telegramBot, event)
+		}
+
+		tracked := true
+		if callback := c.Callback(); callback != nil {
+			event := base
+			event.Name, event.Data = analytics.EventButtonClick, callback.Data
+			track(event)
+		} else if msg := c.Message(); msg != nil && strings.HasPrefix(msg.Text, "/") {
+			event := base
+			event.Name = analytics.EventCommand
+			event.Command, _, _ = strings.Cut(strings.Fields(msg.Text)[0], "@")
+			track(event)
+		} else {
+			tracked = false
+		}
+		if query, ok := c.Get(reaction.SearchQueryKey).(string); ok {
+			event := base
+			event.Name, event.Query = analytics.EventSearch, query
+			if event.Results, _ = c.Get(reaction.SearchResultsKey).(int); event.Results == 0 {
+				event.Name = analytics.EventSearchMiss
+			}
+			track(event)
+		} else if !tracked && reactionID != 0 {
+			event := base
+			event.Name = analytics.EventReaction
+			track(event)
+		}
+		return err
+	}
+}
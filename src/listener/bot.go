@@ -2,79 +2,170 @@ package listener
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"telegram-listener/analytics"
 	"telegram-listener/database"
+	"telegram-listener/metrics"
 	"telegram-listener/reaction"
+	"telegram-listener/serv"
 	"time"
 
 	"gopkg.in/telebot.v4"
 )
 
 type FlixBot struct {
-	telegramBot database.TelegramBot
-	TgBot       *telebot.Bot
+	telegramBot      database.TelegramBot
+	TgBot            *telebot.Bot
+	httpService      *serv.Service
+	analyticsService *analytics.Service
+	webhook          *telebot.Webhook // nil for long polling
+	startedAt        time.Time
+	started          bool
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt *time.Time
+	fatalAt     time.Time // of the last poller error that stops updates
+	fatalReason string
 }
 
+var webhookInfoClient = &http.Client{Timeout: 10 * time.Second}
+
+// Register sets the bot up with Telegram. The bot gets updates only after
+// start, which the listener calls once the bot is the current one.
 func (flixBot *FlixBot) Register(dbService *database.Service, reactionService *reaction.Service) (err error) {
 	log.Println("Registering bot:", flixBot.telegramBot.ID, flixBot.telegramBot.Name)
 	pref := telebot.Settings{
-		Token:  flixBot.telegramBot.Token,
-		Poller: &telebot.LongPoller{Timeout: 10 * time.Second},
+		Token:   flixBot.telegramBot.Token,
+		Poller:  &telebot.LongPoller{Timeout: 10 * time.Second},
+		OnError: flixBot.onError,
+	}
+	if flixBot.isWebhook() {
+		if !strings.HasPrefix(flixBot.telegramBot.ListenURL, "https://") {
+			return fmt.Errorf("webhook needs an https listen_url, got %q", flixBot.telegramBot.ListenURL)
+		}
+		flixBot.webhook = flixBot.newWebhook()
+		pref.Poller = webhookPoller{}
 	}
 
 	log.Println("Bot listen URL:", flixBot.telegramBot.ListenURL)
-	log.Println("Webhook info:", flixBot.getWebhookInfo(flixBot.telegramBot.Token))
+	webhookInfo, err := flixBot.getWebhookInfo(flixBot.telegramBot.Token)
+	if err != nil {
+		return fmt.Errorf("webhook info: %w", err)
+	}
+	log.Println("Webhook info:", webhookInfo)
 
 	flixBot.TgBot, err = telebot.NewBot(pref)
 	if err != nil {
 		if strings.Contains(err.Error(), "Conflict") {
-			log.Fatalf("❌ Уже другой бот слушает webhook: %v", err)
+			return fmt.Errorf("another instance listens to the bot: %w", err)
 		}
-		log.Println("Failed to register bot:", flixBot.telegramBot.ID, flixBot.telegramBot.Name, err)
-		return
+		return fmt.Errorf("new bot: %w", err)
 	}
 
-	log.Println("Starting bot:", flixBot.telegramBot.ID, flixBot.telegramBot.Name)
+	if flixBot.webhook != nil {
+		log.Println("Setting webhook:", flixBot.telegramBot.ID, flixBot.webhook.Endpoint.PublicURL)
+		if err = flixBot.TgBot.SetWebhook(flixBot.webhook); err != nil {
+			return fmt.Errorf("set webhook: %w", err)
+		}
+	} else if err = flixBot.TgBot.RemoveWebhook(); err != nil { // getUpdates conflicts with an active webhook
+		return fmt.Errorf("remove webhook: %w", err)
+	}
+
+	flixBot.TgBot.Use(flixBot.countUpdates)
+	if flixBot.analyticsService.Enabled(flixBot.telegramBot) {
+		flixBot.TgBot.Use(flixBot.trackEvents)
+	}
 
-	reactionService.RegisterReactions(flixBot.telegramBot.ID, flixBot.telegramBot.AppURL, flixBot.TgBot)
+	reactionService.RegisterReactions(flixBot.telegramBot.ID, flixBot.telegramBot.AppURL, flixBot.telegramBot.SearchURL, flixBot.TgBot)
 
 	// flixBot.TgBot.Handle("/start", func(c telebot.Context) error {
 	// 	return c.Send("Hello, I am your bot!")
 	// })
+	return
+}
+
+// start routes the webhook of the bot to it and starts the poller. The
+// listener calls it under its lock, so a stale bot never takes the route.
+func (flixBot *FlixBot) start() {
+	log.Println("Starting bot:", flixBot.telegramBot.ID, flixBot.telegramBot.Name)
+	if flixBot.webhook != nil {
+		flixBot.httpService.RegisterWebhook(flixBot.telegramBot.ID, flixBot.webhook.SecretToken, flixBot)
+	}
+	flixBot.startedAt = time.Now().UTC()
+	flixBot.started = true
 	go flixBot.TgBot.Start()
+}
 
-	return
+func (flixBot *FlixBot) countUpdates(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		chatType := "none"
+		if c.Chat() != nil {
+			chatType = string(c.Chat().Type)
+		}
+		metrics.Updates.WithLabelValues(flixBot.TgBot.Me.Username, chatType).Inc()
+		return next(c)
+	}
+}
+
+// onError keeps the last error of the bot for the status page. Poller errors
+// (without a context) like Conflict or Unauthorized make the bot unhealthy.
+func (flixBot *FlixBot) onError(err error, c telebot.Context) {
+	message := redact(err)
+	log.Println("bot", flixBot.telegramBot.ID, "error:", message)
+	now := time.Now().UTC()
+	flixBot.mu.Lock()
+	defer flixBot.mu.Unlock()
+	flixBot.lastError = message
+	flixBot.lastErrorAt = &now
+	if reason := fatalReason(err); c == nil && reason != "" {
+		flixBot.fatalAt = now
+		flixBot.fatalReason = reason
+	}
 }
 
+// Stop removes the webhook route of the bot, unless a newer bot with the same
+// ID took it, and stops the poller. A bot that was never started has no
+// poller, telebot's Stop would wait for one forever.
 func (c *FlixBot) Stop() {
 	log.Println("Stopping bot:", c.telegramBot.ID, c.telegramBot.Name)
-	if c.TgBot != nil {
+	if c.webhook != nil {
+		c.httpService.UnregisterWebhook(c.telegramBot.ID, c)
+	}
+	if c.started {
 		c.TgBot.Stop()
 	}
 }
 
-func (c *FlixBot) getWebhookInfo(botToken string) string {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getWebhookInfo", botToken)
+func (c *FlixBot) getWebhookInfo(botToken string) (string, error) {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/getWebhookInfo", botToken)
 
-	resp, err := http.Get(url)
+	resp, err := webhookInfoClient.Get(apiURL)
 	if err != nil {
-		log.Fatalf("Ошибка запроса: %v", err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // its URL holds the token
+		}
+		return "", fmt.Errorf("ошибка запроса: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Неверный код ответа: %d", resp.StatusCode)
+		return "", fmt.Errorf("неверный код ответа: %d", resp.StatusCode)
 	}
 
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Fatalf("Ошибка декодирования JSON: %v", err)
+		return "", fmt.Errorf("ошибка декодирования JSON: %w", err)
 	}
 
 	// Печатаем JSON-ответ Telegram
 	jsonResult, _ := json.MarshalIndent(result, "", "  ")
-	return string(jsonResult)
+	return string(jsonResult), nil
 }
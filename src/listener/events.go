@@ -0,0 +1,52 @@
+package listener
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	BotStarted   = "started"
+	BotRestarted = "restarted"
+	BotStopped   = "stopped"
+	BotFailed    = "failed"
+)
+
+// maxEvents is how many lifecycle events are kept in memory.
+const maxEvents = 200
+
+type BotEvent struct {
+	BotID int       `json:"bot_id"`
+	Name  string    `json:"name"`
+	Event string    `json:"event"`
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// addEvent records a bot lifecycle transition. Callers hold s.mu.
+func (s *Service) addEvent(botID int, name, event string, err error) {
+	e := BotEvent{
+		BotID: botID,
+		Name:  name,
+		Event: event,
+		At:    time.Now().UTC(),
+	}
+	if err != nil {
+		e.Error = redact(err)
+	}
+	log.Printf("bot %d (%s) %s %s", botID, name, event, e.Error)
+
+	s.events = append(s.events, e)
+	if len(s.events) > maxEvents {
+		s.events = s.events[len(s.events)-maxEvents:]
+	}
+}
+
+// Events returns the recent bot lifecycle transitions, oldest first.
+func (s *Service) Events() []BotEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]BotEvent, len(s.events))
+	copy(events, s.events)
+	return events
+}
@@ -0,0 +1,219 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"telegram-listener/database"
+	"telegram-listener/metrics"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	retryMin    = 10 * time.Second
+	retryMax    = 10 * time.Minute
+	retryPeriod = 5 * time.Second
+
+	// unhealthyFor keeps a running bot unhealthy after a poller Conflict or
+	// Unauthorized, the poller repeats them while the problem lasts.
+	unhealthyFor = time.Minute
+)
+
+// Generic reasons of an unhealthy bot, safe to show without authentication.
+const (
+	ReasonRegistration = "registration failed"
+	ReasonConflict     = "another instance polls the bot"
+	ReasonUnauthorized = "token rejected"
+)
+
+var botTokenRe = regexp.MustCompile(`/bot\d+:[A-Za-z0-9_-]+`)
+
+// redact hides the bot token of Telegram API URLs in an error, the same way
+// telebot does for its own errors.
+func redact(err error) string {
+	return botTokenRe.ReplaceAllString(err.Error(), "/bot<token>")
+}
+
+// fatalReason tells poller errors that leave a running bot without updates.
+func fatalReason(err error) string {
+	switch {
+	case errors.Is(err, telebot.ErrUnauthorized):
+		return ReasonUnauthorized
+	case strings.Contains(err.Error(), "Conflict"):
+		return ReasonConflict
+	}
+	return ""
+}
+
+// failedBot is a bot that could not be registered and waits for a retry.
+type failedBot struct {
+	telegramBot database.TelegramBot
+	attempts    int
+	lastError   string
+	failedAt    time.Time
+	nextRetry   time.Time
+}
+
+type BotStatus struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Mode        string     `json:"mode"`
+	Healthy     bool       `json:"healthy"`
+	Reason      string     `json:"reason,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	NextRetry   *time.Time `json:"next_retry,omitempty"`
+}
+
+func backoff(attempts int) time.Duration {
+	d := retryMin
+	for i := 1; i < attempts && d < retryMax; i++ {
+		d *= 2
+	}
+	if d > retryMax {
+		d = retryMax
+	}
+	return d
+}
+
+// markFailed records a failed registration and schedules the next attempt.
+// Callers hold s.mu.
+func (s *Service) markFailed(telegramBot database.TelegramBot, err error) {
+	failed, found := s.failed[telegramBot.ID]
+	if !found || failed.telegramBot.UpdatedAt != telegramBot.UpdatedAt {
+		failed = &failedBot{}
+		s.failed[telegramBot.ID] = failed
+	}
+	now := time.Now().UTC()
+	failed.telegramBot = telegramBot
+	failed.attempts++
+	failed.lastError = redact(err)
+	failed.failedAt = now
+	failed.nextRetry = now.Add(backoff(failed.attempts))
+	metrics.BotUp.WithLabelValues(strconv.Itoa(telegramBot.ID), telegramBot.Name).Set(0)
+	s.addEvent(telegramBot.ID, telegramBot.Name, BotFailed, err)
+}
+
+func (s *Service) retryWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryPeriod):
+		}
+		s.retryFailed()
+	}
+}
+
+// retryFailed registers the failed bots that are due without s.mu, so status,
+// reloads and shutdown don't wait for Telegram, and swaps them in under it.
+func (s *Service) retryFailed() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	due := []database.TelegramBot{}
+	for _, failed := range s.failed {
+		if now.Before(failed.nextRetry) {
+			continue
+		}
+		log.Println("retrying bot:", failed.telegramBot.ID, failed.telegramBot.Name, "attempt:", failed.attempts+1)
+		due = append(due, failed.telegramBot)
+	}
+	s.mu.Unlock()
+
+	for _, telegramBot := range due {
+		newFlixBot, err := s.newBot(telegramBot)
+
+		s.mu.Lock()
+		failed, found := s.failed[telegramBot.ID]
+		current := !s.closed && found && failed.telegramBot.UpdatedAt == telegramBot.UpdatedAt
+		if current {
+			s.addBot(telegramBot, newFlixBot, err, BotStarted)
+		}
+		s.mu.Unlock()
+
+		if !current && newFlixBot != nil { // stopped, changed or removed meanwhile
+			newFlixBot.Stop()
+		}
+	}
+}
+
+// Status lists running and failed bots ordered by ID.
+func (s *Service) Status() []BotStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]BotStatus, 0, len(s.bots)+len(s.failed))
+	for _, bot := range s.bots {
+		startedAt := bot.startedAt
+		status := BotStatus{
+			ID:        bot.telegramBot.ID,
+			Name:      bot.telegramBot.Name,
+			Mode:      bot.mode(),
+			StartedAt: &startedAt,
+		}
+		bot.mu.Lock()
+		status.LastError = bot.lastError
+		status.LastErrorAt = bot.lastErrorAt
+		status.Healthy = time.Since(bot.fatalAt) > unhealthyFor
+		if !status.Healthy {
+			status.Reason = bot.fatalReason
+		}
+		bot.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	for _, failed := range s.failed {
+		failedAt, nextRetry := failed.failedAt, failed.nextRetry
+		bot := FlixBot{telegramBot: failed.telegramBot}
+		statuses = append(statuses, BotStatus{
+			ID:          failed.telegramBot.ID,
+			Name:        failed.telegramBot.Name,
+			Mode:        bot.mode(),
+			Reason:      ReasonRegistration,
+			LastError:   failed.lastError,
+			LastErrorAt: &failedAt,
+			Attempts:    failed.attempts,
+			NextRetry:   &nextRetry,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// BotHealth is the part of BotStatus shown without authentication, errors
+// and their details are on the admin API.
+type BotHealth struct {
+	ID      int    `json:"id"`
+	Healthy bool   `json:"healthy"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// healthHandler answers 503 when any bot is unhealthy, so it can be probed.
+func (s *Service) healthHandler(w http.ResponseWriter, req *http.Request) {
+	code := http.StatusOK
+	health := []BotHealth{}
+	for _, status := range s.Status() {
+		health = append(health, BotHealth{ID: status.ID, Healthy: status.Healthy, Reason: status.Reason})
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(health)
+}
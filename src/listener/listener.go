@@ -1,80 +1,263 @@
 package listener
 
 import (
+	"context"
 	"log"
+	"net/http"
+	"strconv"
 	"sync"
+	"telegram-listener/analytics"
 	"telegram-listener/database"
+	"telegram-listener/metrics"
 	"telegram-listener/reaction"
 	"telegram-listener/sender"
+	"telegram-listener/serv"
 	"time"
+
+	"gopkg.in/telebot.v4"
 )
 
 type Service struct {
-	mu              sync.RWMutex
-	bots            map[int]*FlixBot
-	dbService       *database.Service
-	senderService   *sender.Service
-	reactionService *reaction.Service
-	updatePeriod    time.Duration
+	reloadMu         sync.Mutex // one loadData at a time, s.mu is held only to update the maps
+	mu               sync.RWMutex
+	bots             map[int]*FlixBot
+	failed           map[int]*failedBot
+	dbService        *database.Service
+	senderService    *sender.Service
+	reactionService  *reaction.Service
+	httpService      *serv.Service
+	analyticsService *analytics.Service
+	updatePeriod     time.Duration
+	events           []BotEvent
+	closed           bool
 }
 
-func NewService(dbService *database.Service, senderService *sender.Service, reactionService *reaction.Service) (s *Service, err error) {
+func NewService(ctx context.Context, dbService *database.Service, senderService *sender.Service, reactionService *reaction.Service, httpService *serv.Service, analyticsService *analytics.Service) (s *Service, err error) {
 	s = &Service{
-		dbService:       dbService,
-		updatePeriod:    time.Second * 60,
-		bots:            make(map[int]*FlixBot),
-		senderService:   senderService,
-		reactionService: reactionService,
+		dbService:        dbService,
+		httpService:      httpService,
+		analyticsService: analyticsService,
+		updatePeriod:     time.Second * 60,
+		bots:             make(map[int]*FlixBot),
+		failed:           make(map[int]*failedBot),
+		senderService:    senderService,
+		reactionService:  reactionService,
 	}
 
 	err = s.loadData()
 
-	go s.loadWorker()
+	go s.loadWorker(ctx)
+	go s.retryWorker(ctx)
+
+	httpService.Handle("GET /bots", http.HandlerFunc(s.healthHandler))
 
 	return
 }
 
-func (s *Service) loadWorker() {
+// GetTgBot returns the running telebot instance of the bot or nil.
+func (s *Service) GetTgBot(botID int) *telebot.Bot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if bot, found := s.bots[botID]; found {
+		return bot.TgBot
+	}
+	return nil
+}
+
+func (s *Service) loadWorker(ctx context.Context) {
 	for {
-		time.Sleep(time.Second * s.updatePeriod)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.updatePeriod):
+		}
 		if err := s.loadData(); err != nil {
 			log.Println(err)
 		}
 	}
 }
 
+// Shutdown stops all bots in parallel and waits for them until ctx is done,
+// long pollers may take up to their timeout to return.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	s.closed = true
+	s.failed = make(map[int]*failedBot)
+	wg := sync.WaitGroup{}
+	for id, bot := range s.bots {
+		wg.Add(1)
+		go func(bot *FlixBot) {
+			defer wg.Done()
+			bot.Stop()
+		}(bot)
+		delete(s.bots, id)
+		s.addEvent(id, bot.telegramBot.Name, BotStopped, nil)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lock takes s.mu unless ctx is done first, then the lock is released as soon
+// as it is taken.
+func (s *Service) lock(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			s.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// Reload syncs running bots with the DB right away.
+func (s *Service) Reload() error {
+	return s.loadData()
+}
+
+// loadData syncs the running bots with the DB. Registrations make network
+// calls and run without s.mu, so status pages, pushes and Shutdown don't wait
+// for Telegram.
 func (s *Service) loadData() (err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return
+	}
+	defer func(start time.Time) { metrics.ObserveReload("bots", start, err) }(time.Now())
 	telegramBots, err := database.LoadBots(s.dbService)
 	if err != nil {
 		log.Println("Failed to load bots:", err)
 		return err
 	}
 
+	loaded := make(map[int]bool, len(telegramBots))
 	for _, botNew := range telegramBots {
-		if botOld, found := s.bots[botNew.ID]; found { // update old bot?
-			log.Printf("update bot %d? ", botOld.telegramBot.ID)
-			if botOld.telegramBot.UpdatedAt == botNew.UpdatedAt {
-				log.Println("no")
-				continue // don't need to restart bot
-			}
-			log.Println("yes")
-			botOld.Stop()
-		}
-		newFlixBot := &FlixBot{
-			telegramBot: botNew,
-		}
-		err = newFlixBot.Register(s.dbService, s.reactionService)
-		if err != nil {
-			log.Println("failed to register bot:", botNew.ID, botNew.Name, err)
+		loaded[botNew.ID] = true
+		s.syncBot(botNew)
+	}
+
+	// unpublished, without listen_url or deleted
+	removed := []*FlixBot{}
+	s.mu.Lock()
+	for id, botOld := range s.bots {
+		if loaded[id] {
 			continue
 		}
+		removed = append(removed, botOld)
+		delete(s.bots, id)
+		metrics.BotUp.DeleteLabelValues(strconv.Itoa(id), botOld.telegramBot.Name)
+		s.addEvent(id, botOld.telegramBot.Name, BotStopped, nil)
+	}
+	for id, failed := range s.failed {
+		if !loaded[id] {
+			delete(s.failed, id)
+			metrics.BotUp.DeleteLabelValues(strconv.Itoa(id), failed.telegramBot.Name)
+		}
+	}
+	log.Println("bots loaded:", len(s.bots), "failed:", len(s.failed))
+	s.mu.Unlock()
 
-		s.bots[botNew.ID] = newFlixBot
-		log.Println("bot registered and started:", botNew.ID, botNew.Name)
+	for _, botOld := range removed {
+		botOld.Stop()
 	}
-	log.Println("bots loaded:", len(s.bots))
 	log.Println("= loading bots...DONE")
 	return
 }
+
+// syncBot starts the bot or restarts it when it changed. The old bot stops
+// before the new one polls, two pollers of one token conflict.
+func (s *Service) syncBot(botNew database.TelegramBot) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if failed, found := s.failed[botNew.ID]; found && failed.telegramBot.UpdatedAt == botNew.UpdatedAt {
+		s.mu.Unlock()
+		return // retryWorker takes care of it
+	}
+	event := BotStarted
+	botOld, found := s.bots[botNew.ID]
+	if found { // update old bot?
+		log.Printf("update bot %d? ", botOld.telegramBot.ID)
+		if botOld.telegramBot.UpdatedAt == botNew.UpdatedAt {
+			s.mu.Unlock()
+			log.Println("no")
+			return // don't need to restart bot
+		}
+		log.Println("yes")
+		delete(s.bots, botNew.ID)
+		metrics.BotUp.DeleteLabelValues(strconv.Itoa(botNew.ID), botOld.telegramBot.Name)
+		event = BotRestarted
+	}
+	delete(s.failed, botNew.ID) // an older version, its retry must not come back
+	s.mu.Unlock()
+
+	if botOld != nil {
+		botOld.Stop()
+	}
+	newFlixBot, err := s.newBot(botNew)
+
+	s.mu.Lock()
+	closed := s.closed
+	if !closed {
+		s.addBot(botNew, newFlixBot, err, event)
+	}
+	s.mu.Unlock()
+	if closed && newFlixBot != nil {
+		newFlixBot.Stop()
+	}
+}
+
+// newBot registers the bot with Telegram, it makes network calls and does not
+// need s.mu.
+func (s *Service) newBot(telegramBot database.TelegramBot) (*FlixBot, error) {
+	newFlixBot := &FlixBot{
+		telegramBot:      telegramBot,
+		httpService:      s.httpService,
+		analyticsService: s.analyticsService,
+	}
+	if err := newFlixBot.Register(s.dbService, s.reactionService); err != nil {
+		return nil, err
+	}
+	return newFlixBot, nil
+}
+
+// addBot records the registration of newBot as running and starts it, or as
+// failed. Callers hold s.mu.
+func (s *Service) addBot(telegramBot database.TelegramBot, newFlixBot *FlixBot, err error, event string) {
+	if err != nil {
+		s.markFailed(telegramBot, err)
+		return
+	}
+
+	delete(s.failed, telegramBot.ID)
+	s.bots[telegramBot.ID] = newFlixBot
+	newFlixBot.start()
+	metrics.BotUp.WithLabelValues(strconv.Itoa(telegramBot.ID), telegramBot.Name).Set(1)
+	s.addEvent(telegramBot.ID, telegramBot.Name, event, nil)
+}
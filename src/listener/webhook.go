@@ -0,0 +1,69 @@
+package listener
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gopkg.in/telebot.v4"
+)
+
+// isWebhook reports whether the bot is served through a webhook. Only
+// mode=webhook switches it, ListenURL is then the public base URL of this
+// service. Bots without the mode keep long polling whatever ListenURL holds.
+func (flixBot *FlixBot) isWebhook() bool {
+	return flixBot.telegramBot.Mode == "webhook"
+}
+
+func (flixBot *FlixBot) mode() string {
+	if flixBot.isWebhook() {
+		return "webhook"
+	}
+	return "longpoll"
+}
+
+// webhookURL is the public URL registered with Telegram for this bot.
+func (flixBot *FlixBot) webhookURL() string {
+	return fmt.Sprintf("%s/incoming/%d", strings.TrimRight(flixBot.telegramBot.ListenURL, "/"), flixBot.telegramBot.ID)
+}
+
+// secretToken is derived from the bot token so it survives restarts and
+// differs between bots.
+func (flixBot *FlixBot) secretToken() string {
+	sum := sha256.Sum256([]byte("webhook:" + flixBot.telegramBot.Token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (flixBot *FlixBot) newWebhook() *telebot.Webhook {
+	return &telebot.Webhook{
+		SecretToken: flixBot.secretToken(),
+		Endpoint: &telebot.WebhookEndpoint{
+			PublicURL: flixBot.webhookURL(),
+		},
+	}
+}
+
+// webhookPoller is the poller of webhook bots. Updates come in through serv,
+// so it only blocks until the bot is stopped. telebot.Webhook without Listen
+// closes the stop channel a second time on Stop and panics.
+type webhookPoller struct{}
+
+func (webhookPoller) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	<-stop
+}
+
+// ServeHTTP receives updates dispatched by serv for this bot. The secret
+// token has already been checked by serv.
+func (flixBot *FlixBot) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var update telebot.Update
+	if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+		log.Println("webhook: cannot decode update for bot", flixBot.telegramBot.ID, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	flixBot.TgBot.ProcessUpdate(update)
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"telegram-listener/admin"
+	"telegram-listener/analytics"
 	"telegram-listener/database"
+	"telegram-listener/helper"
 	"telegram-listener/listener"
+	"telegram-listener/push"
 	"telegram-listener/reaction"
 	"telegram-listener/sender"
 	"telegram-listener/serv"
 	"telegram-listener/settings"
+	"telegram-listener/users"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -45,31 +54,100 @@ func main() {
 		log.Println("dbService OK")
 	}
 
-	settingsService, err := settings.NewService(dbService, 60)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	settingsService, err := settings.NewService(ctx, dbService, 60)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	senderService, err := sender.NewService(dbService)
+	senderService, err := sender.NewService(ctx, dbService)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	reactionService, err := reaction.NewService(dbService, senderService, settingsService)
+	usersService, err := users.NewService(dbService)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = listener.NewService(dbService, senderService, reactionService)
+	reactionService, err := reaction.NewService(ctx, dbService, senderService, settingsService, usersService)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// telegramService.Send(telegramReportGroupID, fmt.Sprintf("dmca started"))
-
 	httpService, err := serv.NewService(os.Getenv("HTTP_PORT"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	httpService.Run()
+
+	analyticsService, err := analytics.NewService(dbService, analytics.Config{
+		GAEndpoint: os.Getenv("GA_ENDPOINT"),
+		GADebug:    os.Getenv("GA_DEBUG") == "1",
+		JSONLPath:  os.Getenv("ANALYTICS_JSONL"),
+		WebhookURL: os.Getenv("ANALYTICS_WEBHOOK_URL"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listenerService, err := listener.NewService(ctx, dbService, senderService, reactionService, httpService, analyticsService)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pushService, err := push.NewService(ctx, dbService, senderService, listenerService, settingsService, analyticsService, 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = admin.NewService(os.Getenv("ADMIN_TOKEN"), dbService, httpService, listenerService, reactionService, settingsService)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// telegramService.Send(telegramReportGroupID, fmt.Sprintf("dmca started"))
+
+	go func() {
+		if err := httpService.Run(); err != nil {
+			log.Println("http server failed:", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("SHUTDOWN")
+
+	shutdownTimeout := 20 * time.Second
+	if os.Getenv("SHUTDOWN_TIMEOUT") != "" {
+		shutdownTimeout = time.Duration(helper.StrToInt(os.Getenv("SHUTDOWN_TIMEOUT"))) * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stop accepting updates first, then let the queued answers and pushes go out
+	if err := listenerService.Shutdown(shutdownCtx); err != nil {
+		log.Println("listener shutdown:", err)
+	}
+	if err := pushService.Shutdown(shutdownCtx); err != nil {
+		log.Println("push shutdown:", err)
+	}
+	if err := senderService.Shutdown(shutdownCtx); err != nil {
+		log.Println("sender shutdown:", err)
+	}
+	if err := usersService.Shutdown(shutdownCtx); err != nil {
+		log.Println("users shutdown:", err)
+	}
+	if err := analyticsService.Shutdown(shutdownCtx); err != nil {
+		log.Println("analytics shutdown:", err)
+	}
+	if err := httpService.Shutdown(shutdownCtx); err != nil {
+		log.Println("http shutdown:", err)
+	}
+	if err := dbService.Close(); err != nil {
+		log.Println("db close:", err)
+	}
+
+	log.Println("STOP")
 }
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The bot label is the Telegram username of the bot, bot_id is telegram_bot.id.
+var (
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_updates_total",
+		Help: "Updates received by bot and chat type.",
+	}, []string{"bot", "chat_type"})
+
+	ReactionsMatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_reactions_matched_total",
+		Help: "Reactions matched by handle.",
+	}, []string{"bot", "handle"})
+
+	SearchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "telegram_search_duration_seconds",
+		Help:    "Search API request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"bot"})
+
+	SearchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_search_errors_total",
+		Help: "Failed search API requests.",
+	}, []string{"bot"})
+
+	InlineQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_inline_queries_total",
+		Help: "Inline queries answered by bot.",
+	}, []string{"bot"})
+
+	InlineChosen = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_inline_chosen_total",
+		Help: "Inline results chosen by users (needs inline feedback in BotFather).",
+	}, []string{"bot"})
+
+	Sends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_sends_total",
+		Help: "Messages sent by bot, method and result (ok, error, blocked: skipped for a user that blocked the bot, canceled: shutdown came before the send slot).",
+	}, []string{"bot", "method", "result"})
+
+	FloodWaits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_flood_waits_total",
+		Help: "429 responses with retry_after.",
+	}, []string{"bot"})
+
+	FloodWaitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_flood_wait_seconds_total",
+		Help: "Seconds asked to wait by 429 responses.",
+	}, []string{"bot"})
+
+	PushMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_push_messages_total",
+		Help: "Push messages by result (sent or failed).",
+	}, []string{"result"})
+
+	PushInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_push_in_progress",
+		Help: "1 while a push is being sent.",
+	})
+
+	PushAffected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_push_affected",
+		Help: "Recipients reached by the current or last push.",
+	})
+
+	AnalyticsEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_analytics_events_total",
+		Help: "Analytics events by sink and result (sent, failed or dropped).",
+	}, []string{"sink", "result"})
+
+	UserQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_user_queue",
+		Help: "Users waiting for the write-behind upsert.",
+	})
+
+	UserUpserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_user_upserts_total",
+		Help: "Users written by the upsert queue by result (ok, error or dropped).",
+	}, []string{"result"})
+
+	ReloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "telegram_reload_duration_seconds",
+		Help:    "Duration of periodic reloads from the DB.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"subsystem"})
+
+	ReloadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_reload_errors_total",
+		Help: "Failed periodic reloads from the DB.",
+	}, []string{"subsystem"})
+
+	BotUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "telegram_bot_up",
+		Help: "1 if the bot is running, 0 if its registration failed.",
+	}, []string{"bot_id", "name"})
+)
+
+// ObserveReload records a reload of subsystem started at start.
+func ObserveReload(subsystem string, start time.Time, err error) {
+	ReloadDuration.WithLabelValues(subsystem).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ReloadErrors.WithLabelValues(subsystem).Inc()
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,209 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"telegram-listener/analytics"
+	"telegram-listener/database"
+	"telegram-listener/listener"
+	"telegram-listener/metrics"
+	"telegram-listener/sender"
+	"telegram-listener/settings"
+	"telegram-listener/tmpl"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const batchSize = 500
+
+type Service struct {
+	dbService        *database.Service
+	senderService    *sender.Service
+	listenerService  *listener.Service
+	settingsService  *settings.Service
+	analyticsService *analytics.Service
+	updatePeriod     time.Duration
+	stopped          chan struct{}
+}
+
+func NewService(ctx context.Context, dbService *database.Service, senderService *sender.Service, listenerService *listener.Service, settingsService *settings.Service, analyticsService *analytics.Service, updatePeriod int) (s *Service, err error) {
+	s = &Service{
+		dbService:        dbService,
+		senderService:    senderService,
+		listenerService:  listenerService,
+		settingsService:  settingsService,
+		analyticsService: analyticsService,
+		updatePeriod:     time.Duration(updatePeriod),
+		stopped:          make(chan struct{}),
+	}
+
+	go s.worker(ctx)
+
+	return
+}
+
+// Shutdown waits for the worker to leave the push it is sending. The worker
+// itself stops when the context passed to NewService is done.
+func (s *Service) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) worker(ctx context.Context) {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * s.updatePeriod):
+		}
+		if err := s.processNext(ctx); err != nil {
+			log.Println("push:", err)
+		}
+	}
+}
+
+// processNext claims one ready push and sends it to its audience.
+func (s *Service) processNext(ctx context.Context) (err error) {
+	push := &database.TelegramPush{}
+	if err = push.SearchTasks(s.dbService); err != nil {
+		return
+	}
+	if push.ID == 0 {
+		return
+	}
+
+	claimed, err := push.Claim(s.dbService)
+	if err != nil || !claimed {
+		return
+	}
+	log.Println("push started:", push.ID, "bot:", push.BotID, "audience:", push.AudienceID)
+	metrics.PushInProgress.Set(1)
+	metrics.PushAffected.Set(float64(push.Affected))
+	defer metrics.PushInProgress.Set(0)
+
+	err = s.run(ctx, push)
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) { // stopped before the whole audience got it
+		log.Println("push interrupted:", push.ID, "affected:", push.Affected)
+		return push.SetStatusReady(s.dbService)
+	}
+	if err != nil {
+		log.Println("push failed:", push.ID, err)
+		return push.SetStatusError(s.dbService)
+	}
+
+	log.Println("push done:", push.ID, "affected:", push.Affected)
+	return push.SetStatusDone(s.dbService)
+}
+
+func (s *Service) run(ctx context.Context, push *database.TelegramPush) (err error) {
+	tbot := s.listenerService.GetTgBot(push.BotID)
+	if tbot == nil {
+		return fmt.Errorf("bot %d is not running", push.BotID)
+	}
+
+	audience := &database.TelegramAudience{}
+	if err = audience.Load(s.dbService, push.AudienceID); err != nil {
+		return fmt.Errorf("audience %d: %w", push.AudienceID, err)
+	}
+
+	bot := &database.TelegramBot{}
+	if err = bot.Load(s.dbService, push.BotID); err != nil {
+		return fmt.Errorf("bot %d: %w", push.BotID, err)
+	}
+	data := tmpl.Data{
+		Bot:     tmpl.Bot{ID: bot.ID, Name: tbot.Me.Username, AppURL: bot.AppURL},
+		Now:     time.Now(),
+		Setting: s.settingsService.Content(bot.ID),
+	}
+
+	switch audience.Type {
+	case database.AudienceTypeChannel:
+		channel := &database.TelegramChannel{}
+		if err = channel.Load(s.dbService, audience.ChannelID); err != nil {
+			return fmt.Errorf("channel %d: %w", audience.ChannelID, err)
+		}
+		if err = s.send(tbot, push, channel.TgID, data); err != nil {
+			metrics.PushMessages.WithLabelValues("failed").Inc()
+			return
+		}
+		s.delivered(push, bot, channel.TgID)
+		return push.UpdateAffected(s.dbService, 0, 1)
+	case database.AudienceTypeUsers:
+		return s.runUsers(ctx, tbot, push, audience, bot, data)
+	default:
+		return fmt.Errorf("unknown audience type %d", audience.Type)
+	}
+}
+
+func (s *Service) runUsers(ctx context.Context, tbot *telebot.Bot, push *database.TelegramPush, audience *database.TelegramAudience, bot *database.TelegramBot, data tmpl.Data) (err error) {
+	lastID := 0
+	for {
+		users, err := database.LoadPushAudience(s.dbService, push.BotID, audience.Query, push.ID, lastID, batchSize)
+		if err != nil {
+			return fmt.Errorf("audience %d query: %w", audience.ID, err)
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		sent := []int64{}
+		for _, user := range users {
+			if ctx.Err() != nil {
+				break
+			}
+			lastID = user.ID
+			stats := tmpl.UserStats{Counter: user.Counter, LastCommand: user.LastCommand}
+			data.User = tmpl.User{
+				ID:        user.TgID,
+				Username:  user.Username,
+				FirstName: user.FirstName,
+				LastName:  user.LastName,
+				Language:  user.LanguageCode,
+				Stats:     func() tmpl.UserStats { return stats },
+			}
+			if user.Language != "" {
+				data.User.Language = user.Language
+			}
+			if err := s.send(tbot, push, user.TgID, data); err != nil {
+				log.Printf("push %d: failed to send to %d: %v", push.ID, user.TgID, err)
+				metrics.PushMessages.WithLabelValues("failed").Inc()
+				continue
+			}
+			s.delivered(push, bot, user.TgID)
+			sent = append(sent, user.TgID)
+		}
+
+		if err := database.UpdateUsersPushID(s.dbService, push.BotID, sent, push.ID); err != nil {
+			log.Printf("push %d: failed to mark users: %v", push.ID, err)
+		}
+		if err := push.UpdateAffected(s.dbService, 0, len(sent)); err != nil {
+			log.Printf("push %d: failed to update affected: %v", push.ID, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// delivered counts a push message that reached the chat.
+func (s *Service) delivered(push *database.TelegramPush, bot *database.TelegramBot, chatID int64) {
+	metrics.PushMessages.WithLabelValues("sent").Inc()
+	metrics.PushAffected.Inc()
+	s.analyticsService.Track(*bot, analytics.Event{Name: analytics.EventPushDelivery, UserID: chatID, PushID: push.ID})
+}
+
+func (s *Service) send(tbot *telebot.Bot, push *database.TelegramPush, chatID int64, data tmpl.Data) error {
+	text := tmpl.Render(push.Text, data)
+	if push.Type == "photo" {
+		return s.senderService.SendPhoto(tbot, push.InlineButtons, push.MenuButtons, chatID, text, push.ImageURL)
+	}
+	return s.senderService.SendText(tbot, chatID, text, push.InlineButtons, push.MenuButtons)
+}
@@ -0,0 +1,206 @@
+package reaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"telegram-listener/database"
+	"telegram-listener/metrics"
+	"telegram-listener/sender"
+	"telegram-listener/tmpl"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Message is one message of an answer, rendered the way the user gets it.
+// OnText sends the messages of an answer in order, Preview returns them.
+type Message struct {
+	ReactionID int    `json:"reaction_id,omitempty"`
+	Type       string `json:"type"` // TypeText, TypePhoto or TypeVideo
+	Text       string `json:"text"` // the caption of photos and videos
+	MediaURL   string `json:"media_url,omitempty"`
+	InlineMenu string `json:"inline_menu,omitempty"`
+	ReplyMenu  string `json:"reply_menu,omitempty"`
+	Delay      int    `json:"delay,omitempty"`       // milliseconds before sending
+	ChatAction string `json:"chat_action,omitempty"` // shown during Delay
+}
+
+// answer is a resolved text message to render.
+type answer struct {
+	botID    int
+	botName  string // search metrics go under it, previews leave it empty
+	appURL   string
+	kind     string
+	reaction *database.TelegramBotReaction // localized
+	msg      string
+	prefix   string // "username: " in groups, before the first message of texts and searches
+	locales  []string
+	data     tmpl.Data
+}
+
+type rendered struct {
+	messages  []Message
+	results   int   // posts found by the search
+	searchErr error // the search failed, messages hold the not_found answer
+}
+
+// resolve finds the reaction to msg of a user in state: a command, a reply
+// button, /language, a reaction of the state or of the default one, then the
+// search fallback. The reaction is localized.
+func (s *Service) resolve(botID int, state string, msg string, locales []string) (kind string, reaction *database.TelegramBotReaction) {
+	if reaction = s.getCommand(botID, msg); reaction != nil {
+		return PreviewCommand, s.localize(reaction, locales)
+	}
+	if reaction = s.getByReplyButton(botID, msg); reaction != nil {
+		return PreviewCommand, s.localize(reaction, locales)
+	}
+	if strings.HasPrefix(msg, "/") && isLanguageCommand(msg) {
+		return PreviewLanguage, nil
+	}
+	reaction = s.match(botID, state, msg)
+	if reaction == nil && state != "" {
+		reaction = s.match(botID, "", msg) // leaving the conversation
+	}
+	if reaction != nil {
+		return PreviewText, s.localize(reaction, locales)
+	}
+	if reaction = s.getSearch(botID); reaction != nil {
+		return PreviewSearch, s.localize(reaction, locales)
+	}
+	return PreviewNone, nil
+}
+
+// render turns the answer into messages: a command with its chain, a text
+// reaction, or the results of the search (a card, a list or the chain of the
+// search reaction when nothing was found).
+func (s *Service) render(a answer) (r rendered) {
+	switch a.kind {
+	case PreviewCommand:
+		for _, step := range append([]*database.TelegramBotReaction{a.reaction}, s.chain(a.reaction)...) {
+			step = s.localize(step, a.locales)
+			r.messages = append(r.messages, reactionMessage(step, tmpl.Render(step.Answer, a.data)))
+		}
+		return
+	case PreviewText:
+		r.messages = append(r.messages, reactionMessage(a.reaction, a.prefix+tmpl.Render(a.reaction.Answer, a.data)))
+		return
+	case PreviewSearch:
+	default:
+		return
+	}
+
+	var posts []Post
+	var hasNext bool
+	if a.botName == "" {
+		posts, hasNext, r.searchErr = s.getPosts(a.reaction.Handle, a.msg, 0, searchLimit)
+	} else {
+		posts, hasNext, r.searchErr = s.fetchPosts(a.botName, a.reaction.Handle, a.msg, 0, searchLimit)
+	}
+	if r.searchErr != nil {
+		answer := s.settingOr(a.botID, "search", "not_found", "Search error. Try later", a.locales...)
+		r.messages = append(r.messages, Message{Type: TypeText, Text: a.prefix + tmpl.Render(answer, a.data)})
+		return
+	}
+	r.results = len(posts)
+
+	if card := s.getSearchCard(a.botID, a.locales); card != nil && len(posts) > 0 && posts[0].Poster != "" {
+		r.messages = append(r.messages, s.cardMessage(a, card, posts[0]))
+		posts = posts[1:]
+		if len(posts) == 0 && !hasNext {
+			return
+		}
+		a.prefix = "" // went on the card
+	}
+
+	inlineMenu, err := s.renderPosts(a.botID, a.appURL, a.msg, 0, posts, hasNext, a.locales)
+	if err != nil {
+		return
+	}
+	if inlineMenu != "" {
+		r.messages = append(r.messages, Message{
+			ReactionID: a.reaction.ID,
+			Type:       TypeText,
+			Text:       a.prefix + tmpl.Render(a.reaction.Answer, a.data),
+			InlineMenu: inlineMenu,
+			ReplyMenu:  a.reaction.ReplyMenu,
+		})
+		return
+	}
+	if len(r.messages) > 0 {
+		return // the card was the only post
+	}
+
+	log.Printf("No posts found for reaction ID %d with handle %s", a.reaction.ID, a.reaction.Handle)
+	for _, step := range s.chain(a.reaction) {
+		step = s.localize(step, a.locales)
+		r.messages = append(r.messages, reactionMessage(step, tmpl.Render(step.Answer, a.data)))
+	}
+	return
+}
+
+// reactionMessage is reaction sent with text, photos and videos without a
+// MediaURL go as text.
+func reactionMessage(reaction *database.TelegramBotReaction, text string) Message {
+	m := Message{
+		ReactionID: reaction.ID,
+		Type:       TypeText,
+		Text:       text,
+		InlineMenu: reaction.InlineMenu,
+		ReplyMenu:  reaction.ReplyMenu,
+		Delay:      reaction.Delay,
+		ChatAction: reaction.ChatAction,
+	}
+	if (reaction.Type == TypePhoto || reaction.Type == TypeVideo) && reaction.MediaURL != "" {
+		m.Type = reaction.Type
+		m.MediaURL = reaction.MediaURL
+	}
+	return m
+}
+
+// cardMessage is the top post as a photo with a watch button.
+func (s *Service) cardMessage(a answer, card *database.Setting, top Post) Message {
+	postURL := fmt.Sprintf("%s%s", a.appURL, top.Slug)
+	buttonTitle := card.Link
+	if buttonTitle == "" {
+		buttonTitle = defaultCardButton
+	}
+	watchMenu, err := json.Marshal(sender.InlineMenu{{Row: []sender.InlineButton{{Title: buttonTitle, Value: postURL}}}})
+	if err != nil {
+		log.Printf("Failed to marshal watch menu: %v", err)
+	}
+	return Message{
+		ReactionID: a.reaction.ID,
+		Type:       TypePhoto,
+		Text:       a.prefix + cardCaption(card, top, postURL, a.data),
+		MediaURL:   top.Poster,
+		InlineMenu: string(watchMenu),
+	}
+}
+
+// reply answers the sender of the update with a resolved reaction: moves
+// their state, renders the answer with their data and sends it.
+func (s *Service) reply(c telebot.Context, botID int, tbot *telebot.Bot, appURL string, state userState, a answer) error {
+	metrics.ReactionsMatched.WithLabelValues(tbot.Me.Username, a.reaction.Handle).Inc()
+	c.Set(ReactionKey, a.reaction.ID)
+	state = s.moveState(botID, c.Sender().ID, state, a.reaction, a.msg)
+	a.botID, a.botName, a.appURL = botID, tbot.Me.Username, appURL
+	a.data = s.templateData(c, botID, tbot, appURL, a.msg, state, a.locales)
+
+	r := s.render(a)
+	if r.searchErr != nil {
+		log.Printf("❌ Failed to search posts for reaction ID %d: %v", a.reaction.ID, r.searchErr)
+	} else if a.kind == PreviewSearch {
+		c.Set(SearchQueryKey, a.msg)
+		c.Set(SearchResultsKey, r.results)
+	}
+
+	chatID, where := s.recipient(c, botID)
+	for _, m := range r.messages {
+		if err := s.sendMessage(tbot, chatID, where, m); err != nil {
+			log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, chatID, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package reaction
+
+import (
+	"log"
+	"strings"
+	"telegram-listener/database"
+
+	"gopkg.in/telebot.v4"
+)
+
+// onCallback routes callback buttons (type "callback" in menus) to the
+// reaction whose handle equals the button value, e.g. "/subscribe" or "more".
+// Search pager buttons are handled by onSearchPage.
+func (s *Service) onCallback(botID int, appURL string, tbot *telebot.Bot) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		data := c.Callback().Data
+		if err := c.Respond(); err != nil { // stops the spinner on the button
+			log.Printf("❌ Failed to answer callback by bot %d: %v", botID, err)
+		}
+
+		if strings.HasPrefix(data, searchPagePrefix) {
+			return s.onSearchPage(c, botID, appURL, tbot, data)
+		}
+		if strings.HasPrefix(data, languagePrefix) {
+			return s.onLanguage(c, botID, tbot, data)
+		}
+
+		reaction := s.getCommand(botID, data)
+		if reaction == nil {
+			reaction = s.getByHandle(botID, data)
+		}
+		if reaction == nil {
+			log.Printf("bot:%d no reaction for callback from %d: %s", botID, c.Sender().ID, data)
+			return nil
+		}
+		return s.answerCommand(c, botID, tbot, appURL, reaction, data)
+	}
+}
+
+func (s *Service) getByHandle(botID int, handle string) *database.TelegramBotReaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, reaction := range s.reactions {
+		if reaction.BotID == botID && reaction.Handle == handle {
+			return reaction
+		}
+	}
+	return nil
+}
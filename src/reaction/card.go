@@ -0,0 +1,29 @@
+package reaction
+
+import (
+	"telegram-listener/database"
+	"telegram-listener/tmpl"
+)
+
+// A telegram_settings row with command "search" and part "card" turns on
+// photo cards for the top search result. Its Content is the caption
+// template, Link (optional) the title of the watch button.
+const defaultCardCaption = "<b>[title]</b> [year]"
+const defaultCardButton = "▶ Watch"
+
+func (s *Service) getSearchCard(botID int, locales []string) *database.Setting {
+	card, err := s.settingsService.GetOne(botID, "search", "card", locales...)
+	if err != nil {
+		return nil
+	}
+	return card
+}
+
+func cardCaption(card *database.Setting, post Post, postURL string, data tmpl.Data) string {
+	caption := card.Content
+	if caption == "" {
+		caption = defaultCardCaption
+	}
+	data.Post = tmpl.Post{Title: post.Title, Year: post.Year, URL: postURL}
+	return tmpl.Render(caption, data)
+}
@@ -0,0 +1,93 @@
+package reaction
+
+import (
+	"log"
+	"telegram-listener/database"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	maxChainDepth = 10               // reactions sent after the first one
+	maxStepDelay  = 30 * time.Second // longer Delay is cut to it
+	actionPeriod  = 5 * time.Second  // Telegram shows a chat action that long
+)
+
+const (
+	TypeText  = "text"
+	TypePhoto = "photo"
+	TypeVideo = "video"
+)
+
+// chain lists the reactions following reaction by AdditionalMessageID,
+// stopping at the first one already visited or after maxChainDepth.
+func (s *Service) chain(reaction *database.TelegramBotReaction) (chain []*database.TelegramBotReaction) {
+	seen := map[int]bool{reaction.ID: true}
+	for reaction.AdditionalMessageID > 0 && len(chain) < maxChainDepth {
+		if seen[reaction.AdditionalMessageID] {
+			log.Printf("Reaction chain loop at reaction %d -> %d", reaction.ID, reaction.AdditionalMessageID)
+			break
+		}
+		seen[reaction.AdditionalMessageID] = true
+		reaction = s.getOne(reaction.AdditionalMessageID)
+		if reaction == nil {
+			break
+		}
+		chain = append(chain, reaction)
+	}
+	return
+}
+
+// sendMessage sends one message of an answer: waits Delay showing
+// ChatAction, then sends Text as text or as the caption of the media.
+func (s *Service) sendMessage(tbot *telebot.Bot, chatID int64, where *telebot.SendOptions, m Message) error {
+	if err := s.pause(tbot, chatID, where, m); err != nil {
+		return err
+	}
+
+	switch m.Type {
+	case TypePhoto:
+		return s.senderService.SendPhoto(tbot, m.InlineMenu, m.ReplyMenu, chatID, m.Text, m.MediaURL, where)
+	case TypeVideo:
+		return s.senderService.SendVideo(tbot, m.InlineMenu, m.ReplyMenu, chatID, m.Text, m.MediaURL, where)
+	}
+	return s.senderService.SendText(tbot, chatID, m.Text, m.InlineMenu, m.ReplyMenu, where)
+}
+
+// pause waits Delay of the message, showing its ChatAction meanwhile. It
+// stops early with the error of the context when the app is shutting down.
+func (s *Service) pause(tbot *telebot.Bot, chatID int64, where *telebot.SendOptions, m Message) error {
+	delay := min(time.Duration(m.Delay)*time.Millisecond, maxStepDelay)
+	for {
+		step := delay
+		if m.ChatAction != "" {
+			if err := s.senderService.Notify(tbot, chatID, telebot.ChatAction(m.ChatAction), where); err != nil {
+				log.Printf("Failed to send chat action %s of reaction %d: %v", m.ChatAction, m.ReactionID, err)
+			} else {
+				step = min(delay, actionPeriod)
+			}
+		}
+		if err := s.sleep(step); err != nil {
+			return err
+		}
+		delay -= step
+		if delay <= 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Service) sleep(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
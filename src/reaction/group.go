@@ -0,0 +1,116 @@
+package reaction
+
+import (
+	"strings"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Group behaviour is set per bot by telegram_settings rows with command
+// "group":
+//   - part "policy": comma separated list of what the bot answers in groups,
+//     "mention" (@bot in the text), "command" (/command or /command@bot) and
+//     "reply" (replies to the bot's messages). Empty or "all" answers every message.
+//   - part "reply":  any row makes answers replies to the original message.
+const (
+	GroupAll     = "all"
+	GroupMention = "mention"
+	GroupCommand = "command"
+	GroupReply   = "reply"
+)
+
+func isGroup(c telebot.Context) bool {
+	if c.Chat() == nil {
+		return false
+	}
+	chatType := c.Chat().Type
+	return chatType == telebot.ChatGroup || chatType == telebot.ChatSuperGroup
+}
+
+// groupPolicy returns the enabled group policies of the bot, nil for all.
+func (s *Service) groupPolicy(botID int) map[string]bool {
+	setting, err := s.settingsService.GetOne(botID, "group", "policy")
+	if err != nil {
+		return nil
+	}
+	policy := make(map[string]bool)
+	for _, p := range strings.Split(setting.Content, ",") {
+		p = strings.TrimSpace(p)
+		if p == GroupAll {
+			return nil
+		}
+		if p != "" {
+			policy[p] = true
+		}
+	}
+	if len(policy) == 0 {
+		return nil
+	}
+	return policy
+}
+
+// groupAllowed reports whether the bot answers msg. Messages outside groups
+// are always answered, commands for other bots in a group never are.
+func (s *Service) groupAllowed(c telebot.Context, botID int, tbot *telebot.Bot, msg string) bool {
+	if !isGroup(c) {
+		return true
+	}
+	isCommand := strings.HasPrefix(msg, "/")
+	if isCommand {
+		if _, addressee, found := strings.Cut(strings.Fields(msg)[0], "@"); found && !strings.EqualFold(addressee, tbot.Me.Username) {
+			return false
+		}
+	}
+
+	policy := s.groupPolicy(botID)
+	if policy == nil {
+		return true
+	}
+	if policy[GroupCommand] && isCommand {
+		return true
+	}
+	if policy[GroupMention] && mentions(msg, tbot.Me.Username) {
+		return true
+	}
+	if policy[GroupReply] {
+		if replyTo := c.Message().ReplyTo; replyTo != nil && replyTo.Sender != nil && replyTo.Sender.ID == tbot.Me.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func mentions(msg, username string) bool {
+	return strings.Contains(strings.ToLower(msg), "@"+strings.ToLower(username))
+}
+
+// stripMention removes "@bot" from a group message, so "@bot Matrix" searches
+// for "Matrix".
+func stripMention(msg, username string) string {
+	mention := "@" + strings.ToLower(username)
+	if i := strings.Index(strings.ToLower(msg), mention); i >= 0 {
+		msg = msg[:i] + msg[i+len(mention):]
+	}
+	return strings.TrimSpace(msg)
+}
+
+// recipient returns where the answer to the update goes: the group it came
+// from, in the same forum topic and as a reply when the bot is set to, or the
+// private chat with the sender.
+func (s *Service) recipient(c telebot.Context, botID int) (chatID int64, where *telebot.SendOptions) {
+	if !isGroup(c) {
+		return c.Sender().ID, nil
+	}
+	where = &telebot.SendOptions{}
+	msg := c.Message()
+	if msg != nil && msg.TopicMessage {
+		where.ThreadID = msg.ThreadID
+	}
+	if msg != nil && c.Callback() == nil {
+		if _, err := s.settingsService.GetOne(botID, "group", "reply"); err == nil {
+			where.ReplyTo = msg
+			where.AllowWithoutReply = true
+		}
+	}
+	return c.Chat().ID, where
+}
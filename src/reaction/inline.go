@@ -0,0 +1,198 @@
+package reaction
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram-listener/database"
+	"telegram-listener/helper"
+	"telegram-listener/metrics"
+	"telegram-listener/tmpl"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Inline mode (@bot query) is tuned per bot by telegram_settings rows with
+// command "inline":
+//   - part "message":    template of the sent message, as the search card
+//   - part "photo":      any row answers with photo results instead of articles
+//   - part "personal":   any row marks results as personal for Telegram's cache
+//   - part "cache_time": seconds Telegram may cache the answer (default 300)
+const (
+	inlineLimit          = 20
+	inlineCacheTTL       = 5 * time.Minute
+	maxInlineCache       = 5000
+	defaultInlineMessage = "<b>[title]</b> [year]\n[url]"
+	defaultInlineCache   = 300
+)
+
+type inlineKey struct {
+	botID  int
+	query  string
+	offset int
+}
+
+type inlineEntry struct {
+	posts   []Post
+	hasNext bool
+	expires time.Time
+}
+
+// inlineCache keeps search API answers, users type the same prefixes a lot.
+type inlineCache struct {
+	mu      sync.Mutex
+	entries map[inlineKey]inlineEntry
+}
+
+func newInlineCache() *inlineCache {
+	return &inlineCache{entries: make(map[inlineKey]inlineEntry)}
+}
+
+func (ic *inlineCache) get(key inlineKey) (inlineEntry, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	entry, found := ic.entries[key]
+	if !found || time.Now().After(entry.expires) {
+		return inlineEntry{}, false
+	}
+	return entry, true
+}
+
+func (ic *inlineCache) put(key inlineKey, posts []Post, hasNext bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	now := time.Now()
+	if len(ic.entries) >= maxInlineCache {
+		for k, entry := range ic.entries {
+			if now.After(entry.expires) {
+				delete(ic.entries, k)
+			}
+		}
+	}
+	ic.entries[key] = inlineEntry{posts: posts, hasNext: hasNext, expires: now.Add(inlineCacheTTL)}
+}
+
+// searchAPI is TelegramBot.SearchURL, or the handle of the search reaction
+// for bots without it.
+func (s *Service) searchAPI(botID int, searchURL string) string {
+	if searchURL != "" {
+		return searchURL
+	}
+	if reaction := s.getSearch(botID); reaction != nil {
+		return reaction.Handle
+	}
+	return ""
+}
+
+func (s *Service) onQuery(botID int, appURL, searchURL string, tbot *telebot.Bot) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		query := c.Query()
+		text := strings.TrimSpace(query.Text)
+		offset := helper.StrToInt(query.Offset)
+		metrics.InlineQueries.WithLabelValues(tbot.Me.Username).Inc()
+
+		locales := s.locales(c, botID)
+		response := &telebot.QueryResponse{
+			CacheTime:  defaultInlineCache,
+			IsPersonal: s.hasSetting(botID, "inline", "personal"),
+			Results:    telebot.Results{},
+		}
+		if setting, err := s.settingsService.GetOne(botID, "inline", "cache_time"); err == nil {
+			response.CacheTime = helper.StrToInt(setting.Content)
+		}
+
+		apiURL := s.searchAPI(botID, searchURL)
+		if text == "" || apiURL == "" {
+			return c.Answer(response)
+		}
+
+		key := inlineKey{botID: botID, query: text, offset: offset}
+		entry, found := s.inlineCache.get(key)
+		if !found {
+			posts, hasNext, err := s.fetchPosts(tbot.Me.Username, apiURL, text, offset, inlineLimit)
+			if err != nil {
+				log.Printf("❌ Failed inline search by bot %d: %v", botID, err)
+				return c.Answer(response)
+			}
+			s.inlineCache.put(key, posts, hasNext)
+			entry = inlineEntry{posts: posts, hasNext: hasNext}
+		}
+		if offset == 0 {
+			c.Set(SearchQueryKey, text)
+			c.Set(SearchResultsKey, len(entry.posts))
+		}
+
+		message := &database.Setting{Content: defaultInlineMessage}
+		if setting, err := s.settingsService.GetOne(botID, "inline", "message", locales...); err == nil {
+			message = setting
+		}
+		photos := s.hasSetting(botID, "inline", "photo")
+		data := s.templateData(c, botID, tbot, appURL, text, userState{}, locales)
+		for _, post := range entry.posts {
+			if result := inlineResult(post, appURL, data, message, photos); result != nil {
+				response.Results = append(response.Results, result)
+			}
+		}
+		if entry.hasNext {
+			response.NextOffset = strconv.Itoa(offset + len(entry.posts))
+		}
+
+		if err := c.Answer(response); err != nil {
+			log.Printf("❌ Failed to answer inline query by bot %d: %v", botID, err)
+			return err
+		}
+		return nil
+	}
+}
+
+func inlineResult(post Post, appURL string, data tmpl.Data, message *database.Setting, photos bool) telebot.Result {
+	postURL := fmt.Sprintf("%s%s", appURL, post.Slug)
+	caption := helper.SanitizeTelegramHTML(cardCaption(message, post, postURL, data))
+	markup := &telebot.ReplyMarkup{}
+	markup.Inline(markup.Row(markup.URL(defaultCardButton, postURL)))
+
+	var result telebot.Result
+	if photos {
+		if post.Poster == "" {
+			return nil
+		}
+		result = &telebot.PhotoResult{
+			URL:      post.Poster,
+			ThumbURL: post.Poster,
+			Title:    post.Title,
+			Caption:  caption,
+		}
+	} else {
+		result = &telebot.ArticleResult{
+			Title:       post.Title,
+			Description: post.Year,
+			Text:        caption,
+			URL:         postURL,
+			ThumbURL:    post.Poster,
+		}
+	}
+	result.SetResultID(strconv.Itoa(post.ID))
+	result.SetParseMode(telebot.ModeHTML)
+	result.SetReplyMarkup(markup)
+	return result
+}
+
+// onInlineResult tracks which result was sent, Telegram only reports it when
+// inline feedback is enabled in BotFather.
+func (s *Service) onInlineResult(botID int, tbot *telebot.Bot) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		result := c.InlineResult()
+		metrics.InlineChosen.WithLabelValues(tbot.Me.Username).Inc()
+		log.Printf("bot:%d inline result %s chosen by %d for: %s", botID, result.ResultID, result.Sender.ID, result.Query)
+		s.upsertUser(c, botID, "inline:"+result.Query)
+		return nil
+	}
+}
+
+func (s *Service) hasSetting(botID int, command, part string) bool {
+	_, err := s.settingsService.GetOne(botID, command, part)
+	return err == nil
+}
@@ -0,0 +1,210 @@
+package reaction
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"telegram-listener/database"
+	"telegram-listener/sender"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Reactions and settings have variants by Locale. The locale of a user is the
+// one chosen by /language or the language of the Telegram app, "pt-br" falls
+// back to "pt" and then to the variant without locale.
+//
+// /language (unless the bot has its own reaction for it) lists the locales of
+// the bot. Labels of the buttons are settings command "language" with the
+// locale as part, "title" is the question, "auto" the button to follow the
+// app language and "changed" the answer after the choice.
+const (
+	languageCommand = "/language"
+	languagePrefix  = "lang:"
+)
+
+// localeChain turns a language code into the locales to try, "pt-BR" into
+// "pt-br" and "pt".
+func localeChain(language string) []string {
+	language = strings.ToLower(strings.ReplaceAll(language, "_", "-"))
+	if language == "" {
+		return nil
+	}
+	if lang, _, found := strings.Cut(language, "-"); found {
+		return []string{language, lang}
+	}
+	return []string{language}
+}
+
+// locales are the locales to try for the sender of the update. The language
+// chosen by /language is looked up only for bots with locales, others can
+// not have one.
+func (s *Service) locales(c telebot.Context, botID int) []string {
+	sender := c.Sender()
+	if sender == nil {
+		return nil
+	}
+	return localeChain(s.language(c, botID))
+}
+
+// language is the one chosen by the sender of the update or the language of
+// their Telegram app.
+func (s *Service) language(c telebot.Context, botID int) string {
+	if s.hasLocales(botID) {
+		if user := s.user(c, botID); user != nil && user.Language != "" {
+			return user.Language
+		}
+	}
+	return c.Sender().LanguageCode
+}
+
+func variantKey(reaction *database.TelegramBotReaction) string {
+	return reaction.Handle + "\x00" + reaction.State
+}
+
+// buildVariants groups reactions of every bot that differ only by locale.
+func buildVariants(reactions []*database.TelegramBotReaction) map[int]map[string][]*database.TelegramBotReaction {
+	variants := make(map[int]map[string][]*database.TelegramBotReaction)
+	for _, reaction := range reactions {
+		if variants[reaction.BotID] == nil {
+			variants[reaction.BotID] = make(map[string][]*database.TelegramBotReaction)
+		}
+		key := variantKey(reaction)
+		variants[reaction.BotID][key] = append(variants[reaction.BotID][key], reaction)
+	}
+	return variants
+}
+
+// localize returns the variant of reaction in the first of locales that has
+// one, the variant without locale when none has.
+func (s *Service) localize(reaction *database.TelegramBotReaction, locales []string) *database.TelegramBotReaction {
+	if reaction == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	variants := s.variants[reaction.BotID][variantKey(reaction)]
+	for _, locale := range append(locales, "") {
+		for _, variant := range variants {
+			if strings.EqualFold(variant.Locale, locale) {
+				return variant
+			}
+		}
+	}
+	return reaction
+}
+
+// botLocales lists the locales of reactions and settings of the bot.
+func (s *Service) botLocales(botID int) []string {
+	seen := map[string]bool{}
+	for _, locale := range s.settingsService.Locales(botID) {
+		seen[locale] = true
+	}
+	for _, reaction := range s.getAllReactions() {
+		if reaction.BotID == botID && reaction.Locale != "" {
+			seen[strings.ToLower(reaction.Locale)] = true
+		}
+	}
+	locales := make([]string, 0, len(seen))
+	for locale := range seen {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func isLanguageCommand(msg string) bool {
+	command, _, _ := strings.Cut(strings.Fields(msg)[0], "@")
+	return command == languageCommand
+}
+
+// askLanguage answers /language with a button for every locale of the bot.
+func (s *Service) askLanguage(c telebot.Context, botID int, tbot *telebot.Bot) error {
+	m, err := s.languageMessage(botID, s.locales(c, botID))
+	if err != nil {
+		return err
+	}
+	chatID, where := s.recipient(c, botID)
+	err = s.sendMessage(tbot, chatID, where, m)
+	if err != nil {
+		log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, chatID, err)
+	}
+	return err
+}
+
+func (s *Service) languageMessage(botID int, locales []string) (Message, error) {
+	menu := sender.InlineMenu{}
+	for _, locale := range s.botLocales(botID) {
+		menu = append(menu, sender.InlineMenuRow{Row: []sender.InlineButton{{
+			Title: s.settingOr(botID, "language", locale, locale, locales...),
+			Value: languagePrefix + locale,
+			Type:  sender.ButtonCallback,
+		}}})
+	}
+	menu = append(menu, sender.InlineMenuRow{Row: []sender.InlineButton{{
+		Title: s.settingOr(botID, "language", "auto", "Auto", locales...),
+		Value: languagePrefix,
+		Type:  sender.ButtonCallback,
+	}}})
+	inlineMenu, err := json.Marshal(menu)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Type:       TypeText,
+		Text:       s.settingOr(botID, "language", "title", "Choose language", locales...),
+		InlineMenu: string(inlineMenu),
+	}, nil
+}
+
+// onLanguage stores the locale chosen by a button of askLanguage.
+func (s *Service) onLanguage(c telebot.Context, botID int, tbot *telebot.Bot, data string) error {
+	language := strings.TrimPrefix(data, languagePrefix)
+	if language != "" && !s.hasLocale(botID, language) {
+		log.Printf("bot:%d unknown language from %d: %s", botID, c.Sender().ID, language)
+		return nil
+	}
+	s.upsertUser(c, botID, languageCommand)
+	if err := database.SetUserLanguage(s.dbService, botID, c.Sender().ID, language); err != nil {
+		log.Printf("❌ Failed to set language of user %d of bot %d: %v", c.Sender().ID, botID, err)
+		return err
+	}
+	if user := s.user(c, botID); user != nil {
+		user.Language = language
+	}
+
+	chatID, where := s.recipient(c, botID)
+	err := s.senderService.SendText(tbot, chatID, s.settingOr(botID, "language", "changed", "✅", s.locales(c, botID)...), "", "", where)
+	if err != nil {
+		log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, chatID, err)
+	}
+	return err
+}
+
+func (s *Service) hasLocales(botID int) bool {
+	s.mu.RLock()
+	found := s.localeBots[botID]
+	s.mu.RUnlock()
+	return found || len(s.settingsService.Locales(botID)) > 0
+}
+
+// buildLocaleBots lists the bots having reactions with a Locale.
+func buildLocaleBots(reactions []*database.TelegramBotReaction) map[int]bool {
+	bots := make(map[int]bool)
+	for _, reaction := range reactions {
+		if reaction.Locale != "" {
+			bots[reaction.BotID] = true
+		}
+	}
+	return bots
+}
+
+func (s *Service) hasLocale(botID int, locale string) bool {
+	for _, l := range s.botLocales(botID) {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package reaction
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"telegram-listener/database"
+	"unicode"
+)
+
+// Match types of a reaction (TelegramBotReaction.MatchType). Handle holds
+// the text, the pattern or the comma separated keywords.
+const (
+	MatchExact    = "exact"    // text equals handle
+	MatchExactCI  = "exact_ci" // text equals handle ignoring case, the default
+	MatchPrefix   = "prefix"   // text starts with handle, ignoring case
+	MatchRegex    = "regex"    // text matches the regexp in handle
+	MatchKeywords = "keywords" // text has one of the words in handle
+	MatchFuzzy    = "fuzzy"    // text is similar to handle by MatchThreshold
+)
+
+const defaultFuzzyThreshold = 0.8
+
+// matchRank orders match types of the same priority, stricter ones first.
+var matchRank = map[string]int{
+	MatchExact:    0,
+	MatchExactCI:  1,
+	MatchPrefix:   2,
+	MatchRegex:    3,
+	MatchKeywords: 4,
+	MatchFuzzy:    5,
+}
+
+type rule struct {
+	reaction *database.TelegramBotReaction
+	kind     string
+	handle   string // lower cased for all but exact and regex
+	pattern  *regexp.Regexp
+	keywords map[string]bool
+}
+
+// matcher picks the reaction for a text message. The result does not depend
+// on the order of reactions in the DB: the highest priority wins, then the
+// stricter match type, then the better score, then the lower ID.
+type matcher struct {
+	rules map[int][]rule // bot ID -> rules
+}
+
+func newMatcher(reactions []*database.TelegramBotReaction) *matcher {
+	m := &matcher{rules: make(map[int][]rule)}
+	for _, reaction := range reactions {
+		if isSearchReaction(reaction) {
+			continue
+		}
+		r, err := newRule(reaction)
+		if err != nil {
+			log.Printf("❌ Failed to compile reaction %d: %v", reaction.ID, err)
+			continue
+		}
+		m.rules[reaction.BotID] = append(m.rules[reaction.BotID], r)
+	}
+	return m
+}
+
+func newRule(reaction *database.TelegramBotReaction) (r rule, err error) {
+	r = rule{reaction: reaction, kind: reaction.MatchType}
+	if _, found := matchRank[r.kind]; !found {
+		r.kind = MatchExactCI
+	}
+	switch r.kind {
+	case MatchExact:
+		r.handle = reaction.Handle
+	case MatchRegex:
+		if reaction.Handle == "" {
+			return r, errors.New("empty regexp matches every message")
+		}
+		r.pattern, err = regexp.Compile(reaction.Handle)
+	case MatchKeywords:
+		r.keywords = make(map[string]bool)
+		for _, keyword := range strings.Split(reaction.Handle, ",") {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				r.keywords[keyword] = true
+			}
+		}
+	default:
+		r.handle = strings.ToLower(strings.TrimSpace(reaction.Handle))
+	}
+	return
+}
+
+// Validate reports a reaction that can never match, e.g. a broken regexp.
+func (s *Service) Validate(reaction *database.TelegramBotReaction) error {
+	_, err := newRule(reaction)
+	return err
+}
+
+// score returns how well msg matches, 0 for no match.
+func (r rule) score(msg string) float64 {
+	switch r.kind {
+	case MatchExact:
+		if msg == r.handle {
+			return 1
+		}
+	case MatchExactCI:
+		if strings.ToLower(strings.TrimSpace(msg)) == r.handle {
+			return 1
+		}
+	case MatchPrefix:
+		if r.handle != "" && strings.HasPrefix(strings.ToLower(msg), r.handle) {
+			return float64(len(r.handle)) / float64(len(msg)) // longer handle is a closer match
+		}
+	case MatchRegex:
+		if r.pattern.MatchString(msg) {
+			return 1
+		}
+	case MatchKeywords:
+		found := 0
+		for _, word := range words(msg) {
+			if r.keywords[word] {
+				found++
+			}
+		}
+		if found > 0 {
+			return float64(found) / float64(len(r.keywords))
+		}
+	case MatchFuzzy:
+		threshold := r.reaction.MatchThreshold
+		if threshold <= 0 {
+			threshold = defaultFuzzyThreshold
+		}
+		if similarity := similarity(strings.ToLower(strings.TrimSpace(msg)), r.handle); similarity >= threshold {
+			return similarity
+		}
+	}
+	return 0
+}
+
+// match looks among the reactions of the conversation state, see state.go.
+func (m *matcher) match(botID int, state string, msg string) *database.TelegramBotReaction {
+	var best *rule
+	var bestScore float64
+	for i := range m.rules[botID] {
+		r := &m.rules[botID][i]
+		if r.reaction.State != state {
+			continue
+		}
+		score := r.score(msg)
+		if score == 0 {
+			continue
+		}
+		if best == nil || better(r, score, best, bestScore) {
+			best, bestScore = r, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.reaction
+}
+
+func better(r *rule, score float64, than *rule, thanScore float64) bool {
+	if r.reaction.Priority != than.reaction.Priority {
+		return r.reaction.Priority > than.reaction.Priority
+	}
+	if matchRank[r.kind] != matchRank[than.kind] {
+		return matchRank[r.kind] < matchRank[than.kind]
+	}
+	if score != thanScore {
+		return score > thanScore
+	}
+	return r.reaction.ID < than.reaction.ID
+}
+
+func words(msg string) []string {
+	return strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarity is 1 minus the Levenshtein distance of a and b divided by the
+// length of the longer one.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// isSearchReaction tells the search fallback of a bot. Reactions made before
+// IsSearch are recognised by the search API URL in Handle.
+func isSearchReaction(reaction *database.TelegramBotReaction) bool {
+	return reaction.IsSearch || strings.HasPrefix(reaction.Handle, "https://")
+}
+
+// searchReactions indexes the search fallback of every bot, the marked one
+// before a legacy one, the lowest ID among equals.
+func searchReactions(reactions []*database.TelegramBotReaction) map[int]*database.TelegramBotReaction {
+	sorted := make([]*database.TelegramBotReaction, 0)
+	for _, reaction := range reactions {
+		if isSearchReaction(reaction) && reaction.State == "" {
+			sorted = append(sorted, reaction)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].IsSearch != sorted[j].IsSearch {
+			return sorted[i].IsSearch
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	search := make(map[int]*database.TelegramBotReaction)
+	for _, reaction := range sorted {
+		if _, found := search[reaction.BotID]; !found {
+			search[reaction.BotID] = reaction
+		}
+	}
+	return search
+}
@@ -0,0 +1,264 @@
+package reaction
+
+import (
+	"telegram-listener/database"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions []*database.TelegramBotReaction
+		state     string
+		msg       string
+		want      int // reaction ID, 0 for no match
+	}{
+		{
+			name:      "exact is case sensitive",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "Help", MatchType: MatchExact}},
+			msg:       "help",
+		},
+		{
+			name:      "exact",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "Help", MatchType: MatchExact}},
+			msg:       "Help",
+			want:      1,
+		},
+		{
+			name:      "exact ignoring case is the default",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "Help"}},
+			msg:       " HELP ",
+			want:      1,
+		},
+		{
+			name:      "short text does not match a longer handle",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "help"}},
+			msg:       "h",
+		},
+		{
+			name:      "prefix",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "movie", MatchType: MatchPrefix}},
+			msg:       "Movie Matrix",
+			want:      1,
+		},
+		{
+			name:      "prefix is not contains",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "movie", MatchType: MatchPrefix}},
+			msg:       "a movie",
+		},
+		{
+			name:      "keywords match a word anywhere in the text",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "price, cost", MatchType: MatchKeywords}},
+			msg:       "What does it cost?",
+			want:      1,
+		},
+		{
+			name:      "keywords match whole words only",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "cost", MatchType: MatchKeywords}},
+			msg:       "costume",
+		},
+		{
+			name:      "regex",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: `^\d{4}$`, MatchType: MatchRegex}},
+			msg:       "1999",
+			want:      1,
+		},
+		{
+			name:      "regex without a match",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: `^\d{4}$`, MatchType: MatchRegex}},
+			msg:       "19999",
+		},
+		{
+			name:      "empty regex is rejected and matches nothing",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "", MatchType: MatchRegex}},
+			msg:       "anything",
+		},
+		{
+			name:      "broken regex is rejected",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "(", MatchType: MatchRegex}},
+			msg:       "(",
+		},
+		{
+			name:      "fuzzy above the threshold",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "matrix", MatchType: MatchFuzzy}},
+			msg:       "matrx",
+			want:      1,
+		},
+		{
+			name:      "fuzzy below the threshold",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "matrix", MatchType: MatchFuzzy, MatchThreshold: 0.9}},
+			msg:       "matrx",
+		},
+		{
+			name: "higher priority wins over a stricter type",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "help", MatchType: MatchExact},
+				{ID: 2, Handle: "help", MatchType: MatchPrefix, Priority: 1},
+			},
+			msg:  "help",
+			want: 2,
+		},
+		{
+			name: "same priority, stricter type wins",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "help", MatchType: MatchPrefix},
+				{ID: 2, Handle: "help", MatchType: MatchExact},
+			},
+			msg:  "help",
+			want: 2,
+		},
+		{
+			name: "same priority and type, better score wins",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "mov", MatchType: MatchPrefix},
+				{ID: 2, Handle: "movie", MatchType: MatchPrefix},
+			},
+			msg:  "movie night",
+			want: 2,
+		},
+		{
+			name: "full tie goes to the lower ID",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 7, Handle: "help"},
+				{ID: 3, Handle: "help"},
+			},
+			msg:  "help",
+			want: 3,
+		},
+		{
+			name: "default state ignores reactions of other states",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "yes", State: "confirm"},
+			},
+			msg: "yes",
+		},
+		{
+			name: "state only sees its reactions",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "yes"},
+				{ID: 2, Handle: "yes", State: "confirm"},
+			},
+			state: "confirm",
+			msg:   "yes",
+			want:  2,
+		},
+		{
+			name: "search fallback is not matched by text",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "matrix", IsSearch: true},
+				{ID: 2, Handle: "https://search.example/api", MatchType: MatchPrefix},
+			},
+			msg: "https://search.example/api",
+		},
+		{
+			name: "other bots are not matched",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, BotID: 2, Handle: "help"},
+			},
+			msg: "help",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reactions := tt.reactions
+			for _, reaction := range reactions {
+				if reaction.BotID == 0 {
+					reaction.BotID = 1
+				}
+			}
+			// the result must not depend on the order of the reactions
+			for _, order := range [][]*database.TelegramBotReaction{reactions, reversed(reactions)} {
+				got := 0
+				if reaction := newMatcher(order).match(1, tt.state, tt.msg); reaction != nil {
+					got = reaction.ID
+				}
+				if got != tt.want {
+					t.Fatalf("match(%q) = %d, want %d", tt.msg, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func reversed(reactions []*database.TelegramBotReaction) []*database.TelegramBotReaction {
+	result := make([]*database.TelegramBotReaction, len(reactions))
+	for i, reaction := range reactions {
+		result[len(reactions)-1-i] = reaction
+	}
+	return result
+}
+
+func TestSearchReactions(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions []*database.TelegramBotReaction
+		want      int
+	}{
+		{
+			name:      "marked",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "search", IsSearch: true}},
+			want:      1,
+		},
+		{
+			name:      "legacy https handle",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "https://search.example/api"}},
+			want:      1,
+		},
+		{
+			name: "marked before legacy",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 1, Handle: "https://search.example/api"},
+				{ID: 2, Handle: "https://search.example/v2", IsSearch: true},
+			},
+			want: 2,
+		},
+		{
+			name: "lower ID among marked",
+			reactions: []*database.TelegramBotReaction{
+				{ID: 5, Handle: "a", IsSearch: true},
+				{ID: 4, Handle: "b", IsSearch: true},
+			},
+			want: 4,
+		},
+		{
+			name:      "only in the default state",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "search", IsSearch: true, State: "ask"}},
+		},
+		{
+			name:      "plain reaction is not a fallback",
+			reactions: []*database.TelegramBotReaction{{ID: 1, Handle: "help"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, reaction := range tt.reactions {
+				reaction.BotID = 1
+			}
+			got := 0
+			if reaction := searchReactions(tt.reactions)[1]; reaction != nil {
+				got = reaction.ID
+			}
+			if got != tt.want {
+				t.Fatalf("search reaction = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := &Service{}
+	tests := []struct {
+		reaction database.TelegramBotReaction
+		valid    bool
+	}{
+		{database.TelegramBotReaction{Handle: "help"}, true},
+		{database.TelegramBotReaction{Handle: `^\d+$`, MatchType: MatchRegex}, true},
+		{database.TelegramBotReaction{Handle: "", MatchType: MatchRegex}, false},
+		{database.TelegramBotReaction{Handle: "[", MatchType: MatchRegex}, false},
+	}
+	for _, tt := range tests {
+		if err := s.Validate(&tt.reaction); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q, %s) = %v, want valid %v", tt.reaction.Handle, tt.reaction.MatchType, err, tt.valid)
+		}
+	}
+}
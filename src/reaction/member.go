@@ -0,0 +1,38 @@
+package reaction
+
+import (
+	"log"
+	"telegram-listener/database"
+
+	"gopkg.in/telebot.v4"
+)
+
+// onMyChatMember follows users blocking and unblocking the bot in private
+// chats, Telegram reports both as a my_chat_member update.
+func (s *Service) onMyChatMember(botID int, tbot *telebot.Bot) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		update := c.ChatMember()
+		if update == nil || update.Chat == nil || update.Chat.Type != telebot.ChatPrivate || update.NewChatMember == nil {
+			return nil
+		}
+		userID := update.Chat.ID
+
+		switch update.NewChatMember.Role {
+		case telebot.Kicked:
+			log.Printf("bot:%d blocked by %d", botID, userID)
+			s.senderService.Block(tbot, userID)
+			if err := database.DisableUser(s.dbService, botID, userID, database.DisabledBlocked); err != nil {
+				log.Printf("❌ Failed to disable user %d of bot %d: %v", userID, botID, err)
+				return err
+			}
+		case telebot.Member:
+			log.Printf("bot:%d unblocked by %d", botID, userID)
+			s.senderService.Unblock(tbot, userID)
+			if err := database.EnableUser(s.dbService, botID, userID); err != nil {
+				log.Printf("❌ Failed to enable user %d of bot %d: %v", userID, botID, err)
+				return err
+			}
+		}
+		return nil
+	}
+}
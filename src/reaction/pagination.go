@@ -0,0 +1,154 @@
+package reaction
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram-listener/sender"
+	"telegram-listener/tmpl"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Search page callbacks look like "sp:<page>:q:<query>" when the query fits
+// into 64 bytes of callback data and "sp:<page>:c:<cursor>" otherwise.
+const (
+	searchPagePrefix = "sp:"
+	cursorTTL        = 24 * time.Hour
+	maxCursors       = 10000
+)
+
+type cursor struct {
+	query   string
+	expires time.Time
+}
+
+// cursorStore keeps queries too long for callback data.
+type cursorStore struct {
+	mu      sync.Mutex
+	cursors map[string]cursor
+}
+
+func newCursorStore() *cursorStore {
+	return &cursorStore{cursors: make(map[string]cursor)}
+}
+
+func (cs *cursorStore) put(query string) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	now := time.Now()
+	if len(cs.cursors) >= maxCursors {
+		for key, c := range cs.cursors {
+			if now.After(c.expires) {
+				delete(cs.cursors, key)
+			}
+		}
+	}
+	cs.cursors[id] = cursor{query: query, expires: now.Add(cursorTTL)}
+	return id
+}
+
+func (cs *cursorStore) get(id string) (string, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, found := cs.cursors[id]
+	if !found || time.Now().After(c.expires) {
+		return "", false
+	}
+	return c.query, true
+}
+
+func (s *Service) pageData(query string, page int) string {
+	data := fmt.Sprintf("%s%d:q:%s", searchPagePrefix, page, query)
+	if len(data) <= sender.MaxCallbackData {
+		return data
+	}
+	return fmt.Sprintf("%s%d:c:%s", searchPagePrefix, page, s.cursors.put(query))
+}
+
+func (s *Service) parsePageData(data string) (query string, page int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(data, searchPagePrefix), ":", 3)
+	if len(parts) != 3 {
+		return
+	}
+	page, err := strconv.Atoi(parts[0])
+	if err != nil || page < 0 {
+		return
+	}
+	switch parts[1] {
+	case "q":
+		return parts[2], page, true
+	case "c":
+		query, ok = s.cursors.get(parts[2])
+		return
+	}
+	return
+}
+
+func (s *Service) pagerRow(botID int, query string, page int, hasNext bool, locales []string) sender.InlineMenuRow {
+	row := sender.InlineMenuRow{}
+	if page > 0 {
+		row.Row = append(row.Row, sender.InlineButton{
+			Title: s.settingOr(botID, "search", "prev", "◀ Prev", locales...),
+			Value: s.pageData(query, page-1),
+			Type:  sender.ButtonCallback,
+		})
+	}
+	if hasNext {
+		row.Row = append(row.Row, sender.InlineButton{
+			Title: s.settingOr(botID, "search", "next", "Next ▶", locales...),
+			Value: s.pageData(query, page+1),
+			Type:  sender.ButtonCallback,
+		})
+	}
+	return row
+}
+
+func (s *Service) settingOr(botID int, command, part, fallback string, locales ...string) string {
+	if setting, err := s.settingsService.GetOne(botID, command, part, locales...); err == nil && setting != nil {
+		return setting.Content
+	}
+	return fallback
+}
+
+// onSearchPage edits the results message in place with another page.
+func (s *Service) onSearchPage(c telebot.Context, botID int, appURL string, tbot *telebot.Bot, data string) error {
+	query, page, ok := s.parsePageData(data)
+	if !ok {
+		log.Printf("bot:%d expired or broken search page callback: %s", botID, data)
+		return nil
+	}
+
+	locales := s.locales(c, botID)
+	reaction := s.localize(s.getSearch(botID), locales)
+	if reaction == nil {
+		log.Printf("❌ Failed to load search reaction for botID %d", botID)
+		return nil
+	}
+
+	inlineMenu, err := s.searchPosts(botID, tbot.Me.Username, reaction.Handle, appURL, query, page, locales)
+	if err != nil || inlineMenu == "" {
+		log.Printf("❌ Failed to search page %d for bot %d: %v", page, botID, err)
+		return err
+	}
+
+	answer := tmpl.Render(reaction.Answer, s.templateData(c, botID, tbot, appURL, query, userState{}, locales))
+	chatID := c.Sender().ID
+	if c.Chat() != nil { // nil for messages sent in inline mode
+		chatID = c.Chat().ID
+	}
+	err = s.senderService.EditText(tbot, chatID, c.Callback(), answer, inlineMenu)
+	if err != nil {
+		log.Printf("❌ Failed to edit search results by bot %d: %v", botID, err)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package reaction
+
+import (
+	"telegram-listener/database"
+	"telegram-listener/tmpl"
+	"time"
+)
+
+// Kinds of answers to a text message.
+const (
+	PreviewCommand  = "command" // a command or a reply button, sent with its chain
+	PreviewText     = "text"
+	PreviewSearch   = "search"
+	PreviewLanguage = "language"
+	PreviewNone     = "none"
+)
+
+// Preview describes how a bot would answer a message, without sending it.
+type Preview struct {
+	Kind        string                        `json:"kind"`
+	Reaction    *database.TelegramBotReaction `json:"reaction,omitempty"`
+	NextState   string                        `json:"next_state,omitempty"`
+	Messages    []Message                     `json:"messages,omitempty"`
+	SearchError string                        `json:"search_error,omitempty"`
+}
+
+// Preview answers msg of a user in the conversation state (empty for the
+// default one) with the app language locale the way OnText does: the same
+// reaction, locale variants, search card and chain. Answers are rendered for
+// an unknown user, the bot name is the one in the DB.
+func (s *Service) Preview(bot database.TelegramBot, state string, locale string, msg string) (p Preview) {
+	locales := localeChain(locale)
+	p.Kind, p.Reaction = s.resolve(bot.ID, state, msg, locales)
+	switch p.Kind {
+	case PreviewNone:
+		return
+	case PreviewLanguage:
+		m, err := s.languageMessage(bot.ID, locales)
+		if err == nil {
+			p.Messages = []Message{m}
+		}
+		return
+	}
+
+	next := nextState(userState{Name: state}, p.Reaction, msg)
+	p.NextState = next.Name
+	r := s.render(answer{
+		botID:    bot.ID,
+		appURL:   bot.AppURL,
+		kind:     p.Kind,
+		reaction: p.Reaction,
+		msg:      msg,
+		locales:  locales,
+		data: tmpl.Data{
+			Message: msg,
+			User:    tmpl.User{Language: locale},
+			Bot:     tmpl.Bot{ID: bot.ID, Name: bot.Name, AppURL: bot.AppURL},
+			State:   next.Data,
+			Now:     time.Now(),
+			Setting: s.settingsService.Content(bot.ID, locales...),
+		},
+	})
+	p.Messages = r.messages
+	if r.searchErr != nil {
+		p.SearchError = r.searchErr.Error()
+	}
+	return
+}
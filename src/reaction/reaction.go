@@ -1,12 +1,15 @@
 package reaction
 
 import (
+	"context"
 	"log"
 	"strings"
 	"sync"
 	"telegram-listener/database"
+	"telegram-listener/metrics"
 	"telegram-listener/sender"
 	"telegram-listener/settings"
+	"telegram-listener/users"
 	"time"
 
 	"gopkg.in/telebot.v4"
@@ -15,24 +18,39 @@ import (
 type Service struct {
 	mu              sync.RWMutex
 	reactions       []*database.TelegramBotReaction
+	replyButtons    map[int]map[string]string                          // bot ID -> reply button title -> handle
+	variants        map[int]map[string][]*database.TelegramBotReaction // bot ID -> handle and state -> locale variants
+	matcher         *matcher
+	search          map[int]*database.TelegramBotReaction // bot ID -> search fallback
+	stateBots       map[int]bool                          // bots with conversation states
+	localeBots      map[int]bool                          // bots with localized reactions
 	dbService       *database.Service
 	updatePeriod    time.Duration
 	senderService   *sender.Service
 	settingsService *settings.Service
+	usersService    *users.Service
+	cursors         *cursorStore
+	inlineCache     *inlineCache
+	ctx             context.Context // of the app, cuts the pauses of chains on shutdown
 }
 
-func NewService(dbService *database.Service, senderService *sender.Service, settingsService *settings.Service) (s *Service, err error) {
+func NewService(ctx context.Context, dbService *database.Service, senderService *sender.Service, settingsService *settings.Service, usersService *users.Service) (s *Service, err error) {
 	s = &Service{
 		dbService:       dbService,
 		senderService:   senderService,
 		updatePeriod:    time.Second * 60,
 		reactions:       []*database.TelegramBotReaction{},
+		matcher:         newMatcher(nil),
 		settingsService: settingsService,
+		usersService:    usersService,
+		cursors:         newCursorStore(),
+		inlineCache:     newInlineCache(),
+		ctx:             ctx,
 	}
 
 	err = s.loadData()
 
-	go s.loadWorker()
+	go s.loadWorker(ctx)
 
 	return
 }
@@ -54,7 +72,7 @@ func (s *Service) getOne(id int) *database.TelegramBotReaction {
 	return nil
 }
 
-func (s *Service) RegisterReactions(botID int, appURL string, tbot *telebot.Bot) {
+func (s *Service) RegisterReactions(botID int, appURL, searchURL string, tbot *telebot.Bot) {
 
 	// tbot.Use(func(next telebot.HandlerFunc) telebot.HandlerFunc {
 	// 	return func(c telebot.Context) error {
@@ -63,47 +81,43 @@ func (s *Service) RegisterReactions(botID int, appURL string, tbot *telebot.Bot)
 	// 	}
 	// })
 
-	reactions := s.getAllReactions()
-	for _, reaction := range reactions {
-		if reaction.BotID == botID && reaction.Handle != "" {
-			log.Println("Registering reaction:", reaction.Handle, "for bot ID:", botID)
-			// add or update user
-			if strings.HasPrefix(reaction.Handle, "/") { // command
-				tbot.Handle(reaction.Handle, func(c telebot.Context) error {
-					msg := c.Text() // Получаем текст сообщения
-					if len(msg) > 200 {
-						msg = msg[:200] // Ограничиваем длину сообщения до 200 символов
-					}
-					database.UpsertUser(s.dbService, botID, c.Sender().ID, msg)
-					log.Printf("bot:%d received command from %d (%s): %s", botID, c.Sender().ID, c.Sender().Username, msg)
-					for reaction != nil {
-						err := s.senderService.SendText(tbot, c.Sender().ID, reaction.Answer, reaction.InlineMenu, reaction.ReplyMenu)
-						if err != nil {
-							log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, c.Sender().ID, err)
-							return err
-						}
-						if reaction.AdditionalMessageID > 0 {
-							reaction = s.getOne(reaction.AdditionalMessageID)
-						} else {
-							break
-						}
-					}
-					return nil
-				})
-			}
-		}
-	}
+	tbot.Handle(telebot.OnCallback, s.onCallback(botID, appURL, tbot))
+	tbot.Handle(telebot.OnQuery, s.onQuery(botID, appURL, searchURL, tbot))
+	tbot.Handle(telebot.OnInlineResult, s.onInlineResult(botID, tbot))
+	tbot.Handle(telebot.OnMyChatMember, s.onMyChatMember(botID, tbot))
 
+	// commands are looked up on every update, so reactions changed in the DB
+	// go live with the next reload without re-registering the bot
 	tbot.Handle(telebot.OnText, func(c telebot.Context) error {
 		msg := c.Text() // Получаем текст сообщения
 		if len(msg) > 200 {
 			msg = msg[:200] // Ограничиваем длину сообщения до 200 символов
 		}
 
+		if !s.groupAllowed(c, botID, tbot, msg) {
+			return nil
+		}
+		if isGroup(c) {
+			if msg = stripMention(msg, tbot.Me.Username); msg == "" {
+				return nil
+			}
+		}
+
+		locales := s.locales(c, botID)
+		state := s.loadState(c, botID)
+		kind, reaction := s.resolve(botID, state.Name, msg, locales)
+		switch kind {
+		case PreviewCommand:
+			return s.answerCommand(c, botID, tbot, appURL, reaction, msg)
+		case PreviewLanguage:
+			return s.askLanguage(c, botID, tbot)
+		}
+
 		chatType := c.Chat().Type
 		log.Println("Received message in chat type:", chatType, "from user:", c.Sender().ID, "with text:", msg)
 
 		msgPrefix := ""
+		_, where := s.recipient(c, botID)
 		switch chatType {
 		case telebot.ChatPrivate:
 			// Это личный чат (1:1 с пользователем)
@@ -111,83 +125,57 @@ func (s *Service) RegisterReactions(botID int, appURL string, tbot *telebot.Bot)
 		case telebot.ChatGroup, telebot.ChatSuperGroup:
 			// Это группа или супергруппа
 			log.Println("Сообщение в группе:", c.Chat().Title)
-			msgPrefix = c.Sender().Username + ": "
+			if where.ReplyTo == nil {
+				msgPrefix = c.Sender().Username + ": "
+			}
 		default:
 			log.Println("Другой тип чата:", chatType)
 		}
 
-		database.UpsertUser(s.dbService, botID, c.Sender().ID, msg)
+		s.upsertUser(c, botID, msg)
 		log.Printf("bot:%d received message from %d (%s): %s", botID, c.Sender().ID, c.Sender().Username, msg)
 
-		reaction := s.getOneByHandle(botID, msg)
-
-		// может это команда без слеша?
-		if reaction != nil {
-			log.Printf("Non-slash command: %+v", reaction)
-			log.Printf("Found reaction for message: %s", msg)
-			err := s.senderService.SendText(tbot, c.Sender().ID, msgPrefix+reaction.Answer, reaction.InlineMenu, reaction.ReplyMenu)
-			if err != nil {
-				log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, c.Sender().ID, err)
-			}
-			return err
-		}
-
-		reaction = s.getOneByHandle(botID, "https://")
-		if reaction == nil {
+		if kind == PreviewNone {
 			log.Printf("❌ Failed to load search reaction for botID %d", botID)
 			return nil
 		}
-		inlineMenu, err := s.searchPosts(reaction.Handle, appURL, msg)
-		if err != nil {
-			log.Printf("❌ Failed to search posts for reaction ID %d: %v", reaction.ID, err)
-			answer := "Search error. Try later"
-			settingNotFound, err := s.settingsService.GetOne(botID, "search", "not_found")
-			if err == nil && settingNotFound != nil {
-				answer = settingNotFound.Content
-			}
-			err = s.senderService.SendText(tbot, c.Sender().ID, msgPrefix+answer, inlineMenu, "")
-			if err != nil {
-				log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, c.Sender().ID, err)
-			}
-			return err
-		}
-
-		if inlineMenu != "" {
-			answer := strings.ReplaceAll(reaction.Answer, "[orig-message]", msg)
-			answer = strings.ReplaceAll(answer, "[user-username]", c.Sender().Username)
-			err = s.senderService.SendText(tbot, c.Sender().ID, msgPrefix+answer, inlineMenu, reaction.ReplyMenu)
-			if err != nil {
-				log.Printf("❌ Failed to send message by bot %d to %d: %v", botID, c.Sender().ID, err)
-			}
-			return err
-		}
-
-		if reaction.AdditionalMessageID > 0 {
-			log.Printf("No posts found for reaction ID %d with handle %s", reaction.ID, reaction.Handle)
-			reaction2 := s.getOne(reaction.AdditionalMessageID)
-			answer := strings.ReplaceAll(reaction2.Answer, "[orig-message]", msg)
-			answer = strings.ReplaceAll(answer, "[user-username]", c.Sender().Username)
-			err = s.senderService.SendText(tbot, c.Sender().ID, answer, reaction2.InlineMenu, reaction2.ReplyMenu)
-			if err != nil {
-				log.Printf("❌ Failed to send message ID %d: %v", reaction2.ID, err)
-			}
-			return err
+		if kind == PreviewText {
+			log.Printf("Found reaction for message: %s", msg)
 		}
-
-		return nil
+		return s.reply(c, botID, tbot, appURL, state, answer{kind: kind, reaction: reaction, msg: msg, prefix: msgPrefix, locales: locales})
 	})
 }
 
-func (s *Service) loadWorker() {
+// answerCommand answers a command, a reply button or a callback with the
+// reaction and its chain.
+func (s *Service) answerCommand(c telebot.Context, botID int, tbot *telebot.Bot, appURL string, reaction *database.TelegramBotReaction, msg string) error {
+	s.upsertUser(c, botID, msg)
+	log.Printf("bot:%d received command from %d (%s): %s", botID, c.Sender().ID, c.Sender().Username, msg)
+	locales := s.locales(c, botID)
+	a := answer{kind: PreviewCommand, reaction: s.localize(reaction, locales), msg: msg, locales: locales}
+	return s.reply(c, botID, tbot, appURL, s.loadState(c, botID), a)
+}
+
+func (s *Service) loadWorker(ctx context.Context) {
 	for {
-		time.Sleep(time.Second * s.updatePeriod)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.updatePeriod):
+		}
 		if err := s.loadData(); err != nil {
 			log.Println(err)
 		}
 	}
 }
 
+// Reload reads reactions from the DB right away.
+func (s *Service) Reload() error {
+	return s.loadData()
+}
+
 func (s *Service) loadData() (err error) {
+	defer func(start time.Time) { metrics.ObserveReload("reactions", start, err) }(time.Now())
 	log.Println("Loading reactions from database")
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -197,17 +185,45 @@ func (s *Service) loadData() (err error) {
 		return err
 	}
 	s.reactions = reactions
+	s.replyButtons = buildReplyButtons(reactions)
+	s.matcher = newMatcher(reactions)
+	s.search = searchReactions(reactions)
+	s.variants = buildVariants(reactions)
+	s.stateBots = buildStateBots(reactions)
+	s.localeBots = buildLocaleBots(reactions)
 
 	return
 }
 
-func (s *Service) getOneByHandle(botID int, handle string) *database.TelegramBotReaction {
+// getCommand returns the reaction for a "/command[@bot] payload" message.
+func (s *Service) getCommand(botID int, msg string) *database.TelegramBotReaction {
+	if !strings.HasPrefix(msg, "/") {
+		return nil
+	}
+	command, _, _ := strings.Cut(strings.Fields(msg)[0], "@")
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	for _, reaction := range s.reactions {
-		if reaction.BotID == botID && strings.HasPrefix(reaction.Handle, handle) {
+		if reaction.BotID == botID && reaction.Handle == command {
 			return reaction
 		}
 	}
 	return nil
 }
+
+// match returns the reaction for a text message in the conversation state,
+// see matcher.
+func (s *Service) match(botID int, state string, msg string) *database.TelegramBotReaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.matcher.match(botID, state, msg)
+}
+
+// getSearch returns the search fallback reaction of the bot, its Handle is
+// the search API URL.
+func (s *Service) getSearch(botID int) *database.TelegramBotReaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.search[botID]
+}
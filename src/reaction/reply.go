@@ -0,0 +1,48 @@
+package reaction
+
+import (
+	"log"
+	"telegram-listener/database"
+	"telegram-listener/sender"
+)
+
+// buildReplyButtons indexes reply keyboard buttons that name a reaction in
+// their value, so pressing them answers with that reaction.
+func buildReplyButtons(reactions []*database.TelegramBotReaction) map[int]map[string]string {
+	replyButtons := make(map[int]map[string]string)
+	for _, reaction := range reactions {
+		if reaction.ReplyMenu == "" {
+			continue
+		}
+		replyMenu, err := sender.ParseReplyMenu(reaction.ReplyMenu)
+		if err != nil {
+			log.Printf("Failed to parse reply menu of reaction %d: %v", reaction.ID, err)
+			continue
+		}
+		for _, row := range replyMenu.Rows {
+			for _, btn := range row.Row {
+				if btn.Value == "" || (btn.Type != "" && btn.Type != sender.ButtonText) {
+					continue
+				}
+				if replyButtons[reaction.BotID] == nil {
+					replyButtons[reaction.BotID] = make(map[string]string)
+				}
+				replyButtons[reaction.BotID][btn.Title] = btn.Value
+			}
+		}
+	}
+	return replyButtons
+}
+
+func (s *Service) getByReplyButton(botID int, msg string) *database.TelegramBotReaction {
+	s.mu.RLock()
+	handle, found := s.replyButtons[botID][msg]
+	s.mu.RUnlock()
+	if !found {
+		return nil
+	}
+	if reaction := s.getCommand(botID, handle); reaction != nil {
+		return reaction
+	}
+	return s.getByHandle(botID, handle)
+}
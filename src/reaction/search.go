@@ -6,6 +6,20 @@ import (
 	"log"
 	"net/url"
 	"telegram-listener/helper"
+	"telegram-listener/metrics"
+	"telegram-listener/sender"
+	"time"
+)
+
+// searchLimit is the number of posts on one page of results.
+const searchLimit = 5
+
+// Keys of the search and the reaction of an update in telebot.Context, for
+// analytics.
+const (
+	SearchQueryKey   = "search_query"
+	SearchResultsKey = "search_results"
+	ReactionKey      = "reaction_id"
 )
 
 type Post struct {
@@ -17,50 +31,74 @@ type Post struct {
 	Year   string
 }
 
-type SearchInlineMenuRow struct {
-	Row []struct {
-		Title string `json:"title"`
-		Value string `json:"value"`
-	} `json:"row"`
+// fetchPosts is getPosts counted in the search metrics of the bot.
+func (s *Service) fetchPosts(botName, apiURL, query string, offset, limit int) (posts []Post, hasNext bool, err error) {
+	start := time.Now()
+	posts, hasNext, err = s.getPosts(apiURL, query, offset, limit)
+	metrics.SearchDuration.WithLabelValues(botName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SearchErrors.WithLabelValues(botName).Inc()
+	}
+	return
 }
 
-func (s *Service) searchPosts(apiURL, appURL, query string) (inlineMenu string, err error) {
-	posts := []Post{}
-	limit := 5
-
+// getPosts asks the search API for limit posts from offset, hasNext is set
+// when the API returned more posts than that. Previews call it directly, they
+// are not searches of the bot.
+func (s *Service) getPosts(apiURL, query string, offset, limit int) (posts []Post, hasNext bool, err error) {
 	params := url.Values{}
-	params.Add("limit", fmt.Sprintf("%d", limit))
+	params.Add("limit", fmt.Sprintf("%d", limit+1))
+	params.Add("offset", fmt.Sprintf("%d", offset))
 	params.Add("q", query)
 	u := fmt.Sprintf(apiURL, params.Encode())
 	body, err := helper.GetURL(u)
-	if err == nil {
-		err = json.Unmarshal(body, &posts)
-		if err != nil {
-			log.Printf("api fetched but cant be unmarshalled: %s", err)
-		}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, &posts)
+	if err != nil {
+		log.Printf("api fetched but cant be unmarshalled: %s", err)
+		return
 	}
 	if len(posts) > limit {
 		posts = posts[:limit] // limit the number of posts
+		hasNext = true
+	}
+	return
+}
+
+// searchPosts renders one page of results as an inline menu, with a pager row
+// when there is more than one page.
+func (s *Service) searchPosts(botID int, botName, apiURL, appURL, query string, page int, locales []string) (inlineMenu string, err error) {
+	posts, hasNext, err := s.fetchPosts(botName, apiURL, query, page*searchLimit, searchLimit)
+	if err != nil {
+		return
+	}
+	return s.renderPosts(botID, appURL, query, page, posts, hasNext, locales)
+}
+
+func (s *Service) renderPosts(botID int, appURL, query string, page int, posts []Post, hasNext bool, locales []string) (inlineMenu string, err error) {
+	if len(posts) == 0 && page == 0 && !hasNext {
+		return
 	}
-	if len(posts) > 0 {
-		menu := []SearchInlineMenuRow{}
-		for _, post := range posts {
-			row := SearchInlineMenuRow{}
-			row.Row = append(row.Row, struct {
-				Title string `json:"title"`
-				Value string `json:"value"`
-			}{})
-			row.Row[0].Title = fmt.Sprintf("%s %s", post.Title, post.Year)
-			row.Row[0].Value = fmt.Sprintf("%s%s", appURL, post.Slug)
-			menu = append(menu, row)
-		}
-		inlineMenuBytes, err := json.Marshal(menu)
-		if err != nil {
-			log.Printf("Failed to marshal inline menu: %v", err)
-			return "", err
-		}
-		inlineMenu = string(inlineMenuBytes)
+
+	menu := sender.InlineMenu{}
+	for _, post := range posts {
+		menu = append(menu, sender.InlineMenuRow{Row: []sender.InlineButton{{
+			Title: fmt.Sprintf("%s %s", post.Title, post.Year),
+			Value: fmt.Sprintf("%s%s", appURL, post.Slug),
+		}}})
+	}
+	if page > 0 || hasNext {
+		menu = append(menu, s.pagerRow(botID, query, page, hasNext, locales))
+	}
+
+	inlineMenuBytes, err := json.Marshal(menu)
+	if err != nil {
+		log.Printf("Failed to marshal inline menu: %v", err)
+		return "", err
 	}
+	inlineMenu = string(inlineMenuBytes)
 	//log.Println("searchPosts:", apiURL, "query:", query, "found:", len(posts), "inlineMenu:", inlineMenu)
 	return
 }
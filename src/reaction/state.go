@@ -0,0 +1,102 @@
+package reaction
+
+import (
+	"encoding/json"
+	"log"
+	"telegram-listener/database"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// Conversations are chains of reactions connected by states. A reaction with
+// State answers only users in that state, its NextState is where the user
+// goes after the answer. The text the user sent in a state is kept until the
+// conversation resets and is put into answers by {{index .State "<name>"}}
+// or [state:<name>], e.g. "Comedies of [state:choose_year]". Commands and callback buttons work
+// in any state and, like every answer, move the user to their NextState.
+const defaultStateTimeout = 30 * time.Minute
+
+type userState struct {
+	Name string
+	Data map[string]string // state -> text the user sent in it
+}
+
+// loadState returns the state of the sender of the update, bots without
+// states in their reactions do not look it up.
+func (s *Service) loadState(c telebot.Context, botID int) (state userState) {
+	if !s.hasStates(botID) {
+		return
+	}
+	user := s.user(c, botID)
+	if user == nil {
+		return
+	}
+	name, data := user.ActiveState()
+	state.Name = name
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &state.Data); err != nil {
+			log.Printf("Failed to parse state data of user %d of bot %d: %v", user.TgID, botID, err)
+		}
+	}
+	return
+}
+
+func (s *Service) hasStates(botID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stateBots[botID]
+}
+
+// buildStateBots lists the bots having reactions with a State or a NextState.
+func buildStateBots(reactions []*database.TelegramBotReaction) map[int]bool {
+	bots := make(map[int]bool)
+	for _, reaction := range reactions {
+		if reaction.State != "" || reaction.NextState != "" {
+			bots[reaction.BotID] = true
+		}
+	}
+	return bots
+}
+
+// nextState is the state after the reaction answered msg, see moveState.
+func nextState(state userState, reaction *database.TelegramBotReaction, msg string) userState {
+	next := userState{Name: reaction.NextState, Data: make(map[string]string)}
+	for name, value := range state.Data {
+		next.Data[name] = value
+	}
+	if reaction.State != "" {
+		next.Data[reaction.State] = msg
+	}
+	return next
+}
+
+// moveState records msg as the answer in the state of the reaction and moves
+// the user to its NextState. The returned state holds all answers of the
+// conversation to fill the answer of the reaction, even when it ends there.
+func (s *Service) moveState(botID int, tgID int64, state userState, reaction *database.TelegramBotReaction, msg string) userState {
+	if state.Name == "" && reaction.NextState == "" {
+		return state // nothing to keep outside of conversations
+	}
+	next := nextState(state, reaction, msg)
+
+	data := ""
+	var until *time.Time
+	if next.Name != "" {
+		b, err := json.Marshal(next.Data)
+		if err != nil {
+			log.Printf("Failed to marshal state data: %v", err)
+		}
+		data = string(b)
+		timeout := defaultStateTimeout
+		if reaction.StateTimeout > 0 {
+			timeout = time.Duration(reaction.StateTimeout) * time.Second
+		}
+		t := time.Now().UTC().Add(timeout)
+		until = &t
+	}
+	if err := database.SetUserState(s.dbService, botID, tgID, next.Name, data, until); err != nil {
+		log.Printf("❌ Failed to set state %q of user %d of bot %d: %v", next.Name, tgID, botID, err)
+	}
+	return next
+}
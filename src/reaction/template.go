@@ -0,0 +1,43 @@
+package reaction
+
+import (
+	"telegram-listener/tmpl"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// templateData collects the variables of answers to the sender of the update.
+func (s *Service) templateData(c telebot.Context, botID int, tbot *telebot.Bot, appURL string, msg string, state userState, locales []string) tmpl.Data {
+	data := s.botData(botID, tbot, appURL, locales)
+	data.Message = msg
+	data.State = state.Data
+
+	sender := c.Sender()
+	if sender == nil {
+		return data
+	}
+	data.User = tmpl.User{
+		ID:        sender.ID,
+		Username:  sender.Username,
+		FirstName: sender.FirstName,
+		LastName:  sender.LastName,
+		Language:  s.language(c, botID),
+	}
+	data.User.Stats = func() (stats tmpl.UserStats) {
+		if user := s.user(c, botID); user != nil {
+			stats = tmpl.UserStats{Counter: user.Counter, LastCommand: user.LastCommand}
+		}
+		return
+	}
+	return data
+}
+
+// botData is the part of templateData that does not depend on the user.
+func (s *Service) botData(botID int, tbot *telebot.Bot, appURL string, locales []string) tmpl.Data {
+	return tmpl.Data{
+		Bot:     tmpl.Bot{ID: botID, Name: tbot.Me.Username, AppURL: appURL},
+		Now:     time.Now(),
+		Setting: s.settingsService.Content(botID, locales...),
+	}
+}
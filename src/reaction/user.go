@@ -0,0 +1,56 @@
+package reaction
+
+import (
+	"log"
+	"telegram-listener/database"
+
+	"gopkg.in/telebot.v4"
+)
+
+// userKey keeps the stored row of the sender in telebot.Context.
+const userKey = "user"
+
+// user returns the stored row of the sender of the update, loaded once per
+// update. Nil when the update has no sender or the load failed.
+func (s *Service) user(c telebot.Context, botID int) *database.TelegramUser {
+	if user, ok := c.Get(userKey).(*database.TelegramUser); ok {
+		return user
+	}
+	sender := c.Sender()
+	if sender == nil {
+		return nil
+	}
+	user, err := database.LoadUser(s.dbService, botID, sender.ID)
+	if err != nil {
+		log.Printf("Failed to load user %d of bot %d: %v", sender.ID, botID, err)
+		user = nil
+	}
+	c.Set(userKey, user)
+	return user
+}
+
+// upsertUser queues the sender of the update with its profile and the chat it
+// wrote in.
+func (s *Service) upsertUser(c telebot.Context, botID int, lastCommand string) {
+	sender := c.Sender()
+	if sender == nil {
+		return
+	}
+	user := database.TelegramUser{
+		BotID:        botID,
+		TgID:         sender.ID,
+		LastCommand:  lastCommand,
+		Username:     sender.Username,
+		FirstName:    sender.FirstName,
+		LastName:     sender.LastName,
+		LanguageCode: sender.LanguageCode,
+		IsPremium:    sender.IsPremium,
+	}
+	if chat := c.Chat(); chat != nil {
+		user.ChatType = string(chat.Type)
+	}
+	if tbot, ok := c.Bot().(*telebot.Bot); ok && user.ChatType == database.ChatTypePrivate {
+		s.senderService.Unblock(tbot, sender.ID) // UpsertUsers enables the user again unless blocked since
+	}
+	s.usersService.Upsert(user)
+}
@@ -0,0 +1,100 @@
+package sender
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"telegram-listener/database"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+// ErrBlocked is returned instead of sending to a user that blocked the bot.
+var ErrBlocked = errors.New("user blocked the bot")
+
+const (
+	blockedTTL = 24 * time.Hour // a missed unblock stops skipping the chat after it
+	maxBlocked = 100000         // chats kept, a random one goes when full
+)
+
+type blockedKey struct {
+	token  string
+	chatID int64
+}
+
+// blockedSet holds the chats of users that blocked the bot, in memory only:
+// after a restart the first send to such a chat gets the 403 again and blocks
+// it. Pushes do not depend on it, they select enabled users in MySQL.
+type blockedSet struct {
+	clock Clock
+	mu    sync.Mutex
+	chats map[blockedKey]time.Time // -> blocked until
+}
+
+func newBlockedSet(clock Clock) *blockedSet {
+	return &blockedSet{clock: clock, chats: make(map[blockedKey]time.Time)}
+}
+
+func (b *blockedSet) add(key blockedKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := b.chats[key]; !found && len(b.chats) >= maxBlocked {
+		for old := range b.chats {
+			delete(b.chats, old)
+			break
+		}
+	}
+	b.chats[key] = b.clock.Now().Add(blockedTTL)
+}
+
+func (b *blockedSet) remove(key blockedKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.chats, key)
+}
+
+func (b *blockedSet) has(key blockedKey) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, found := b.chats[key]
+	if found && b.clock.Now().After(until) {
+		delete(b.chats, key)
+		return false
+	}
+	return found
+}
+
+// Block makes the sender skip the chat until Unblock or for blockedTTL.
+func (s *Service) Block(tbot *telebot.Bot, chatID int64) {
+	s.blocked.add(blockedKey{tbot.Token, chatID})
+}
+
+func (s *Service) Unblock(tbot *telebot.Bot, chatID int64) {
+	s.blocked.remove(blockedKey{tbot.Token, chatID})
+}
+
+func (s *Service) isBlocked(tbot *telebot.Bot, chatID int64) bool {
+	return s.blocked.has(blockedKey{tbot.Token, chatID})
+}
+
+// blockedReason maps the errors of users that can't get messages anymore to
+// TelegramUser.DisabledReason.
+func blockedReason(err error) string {
+	switch {
+	case errors.Is(err, telebot.ErrBlockedByUser):
+		return database.DisabledBlocked
+	case errors.Is(err, telebot.ErrUserIsDeactivated):
+		return database.DisabledDeactivated
+	}
+	return ""
+}
+
+// disable blocks the chat after a 403 and marks the user disabled.
+func (s *Service) disable(tbot *telebot.Bot, chatID int64, reason string) {
+	s.Block(tbot, chatID)
+	log.Printf("bot %s: user %d is %s, disabling", tbot.Me.Username, chatID, reason)
+	if err := database.DisableUserOfToken(s.dbService, tbot.Token, chatID, reason); err != nil {
+		log.Printf("❌ Failed to disable user %d of bot %s: %v", chatID, tbot.Me.Username, err)
+	}
+}
@@ -0,0 +1,46 @@
+package sender
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockedSet(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := newBlockedSet(clock)
+	key := blockedKey{"token", 1}
+
+	b.add(key)
+	if !b.has(key) {
+		t.Fatal("blocked chat is not blocked")
+	}
+	if b.has(blockedKey{"other", 1}) {
+		t.Fatal("chat of another bot is blocked")
+	}
+	b.remove(key)
+	if b.has(key) {
+		t.Fatal("unblocked chat is still blocked")
+	}
+
+	b.add(key)
+	clock.now = clock.now.Add(blockedTTL + time.Second)
+	if b.has(key) {
+		t.Fatal("block did not expire")
+	}
+	if len(b.chats) != 0 {
+		t.Fatalf("expired block is kept, %d chats", len(b.chats))
+	}
+}
+
+func TestBlockedSetIsCapped(t *testing.T) {
+	b := newBlockedSet(&fakeClock{})
+	for i := 0; i < maxBlocked+10; i++ {
+		b.add(blockedKey{"token", int64(i)})
+	}
+	if len(b.chats) != maxBlocked {
+		t.Fatalf("%d chats kept, want %d", len(b.chats), maxBlocked)
+	}
+	if !b.has(blockedKey{"token", maxBlocked + 9}) {
+		t.Fatal("latest block was evicted")
+	}
+}
@@ -0,0 +1,187 @@
+package sender
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so the limiter can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error // the error of ctx when it is done first
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Rate is Burst sends at once, then one send per Interval.
+type Rate struct {
+	Interval time.Duration
+	Burst    int
+}
+
+// Limits follow https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+type Limits struct {
+	Bot     Rate // all chats of one bot, ~30 msg/s
+	Private Rate // one private chat, ~1 msg/s
+	Group   Rate // one group or channel, ~20 msg/min
+}
+
+var DefaultLimits = Limits{
+	Bot:     Rate{Interval: time.Second / 30, Burst: 30},
+	Private: Rate{Interval: time.Second, Burst: 3},
+	Group:   Rate{Interval: 3 * time.Second, Burst: 5},
+}
+
+// maxBuckets triggers removal of idle chat buckets.
+const maxBuckets = 10000
+
+// bucket is a GCRA limiter: tat is the theoretical arrival time of the next send.
+type bucket struct {
+	rate Rate
+	tat  time.Time
+}
+
+func (b *bucket) tolerance() time.Duration {
+	return time.Duration(b.rate.Burst-1) * b.rate.Interval
+}
+
+// earliest is the first moment a send fits into the bucket.
+func (b *bucket) earliest(now time.Time) time.Time {
+	at := b.tat.Add(-b.tolerance())
+	if at.Before(now) {
+		return now
+	}
+	return at
+}
+
+func (b *bucket) book(at time.Time) {
+	if b.tat.Before(at) {
+		b.tat = at
+	}
+	b.tat = b.tat.Add(b.rate.Interval)
+}
+
+type chatKey struct {
+	bot  string
+	chat int64
+}
+
+// limiter hands out send slots per bot and per recipient chat. Callers
+// block until their slot comes, so bursts get queued instead of rejected.
+type limiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	limits Limits
+	bots   map[string]*bucket
+	chats  map[chatKey]*bucket
+}
+
+func newLimiter(clock Clock, limits Limits) *limiter {
+	return &limiter{
+		clock:  clock,
+		limits: limits,
+		bots:   make(map[string]*bucket),
+		chats:  make(map[chatKey]*bucket),
+	}
+}
+
+// reserveChat books the next slot of the chat and returns how long the
+// caller has to wait for it.
+func (l *limiter) reserveChat(bot string, chatID int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	chatBucket := l.chatBucket(bot, chatID, now)
+	at := chatBucket.earliest(now)
+	chatBucket.book(at)
+	return at.Sub(now)
+}
+
+// reserveBot books the next slot of the bot, shared by all its chats.
+func (l *limiter) reserveBot(bot string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	botBucket := l.botBucket(bot)
+	at := botBucket.earliest(now)
+	botBucket.book(at)
+	return at.Sub(now)
+}
+
+// wait blocks until the bot may send to the chat or ctx is done. The bot slot
+// is only booked once the chat slot came, so a chat waiting for its own
+// interval or a 429 backoff does not hold up the other chats of the bot.
+func (l *limiter) wait(ctx context.Context, bot string, chatID int64) error {
+	if d := l.reserveChat(bot, chatID); d > 0 {
+		if err := l.clock.Sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+	if d := l.reserveBot(bot); d > 0 {
+		return l.clock.Sleep(ctx, d)
+	}
+	return nil
+}
+
+// backoff keeps the chat closed for d, as asked by a 429 retry_after.
+func (l *limiter) backoff(bot string, chatID int64, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	chatBucket := l.chatBucket(bot, chatID, now)
+	if tat := now.Add(d + chatBucket.tolerance()); chatBucket.tat.Before(tat) {
+		chatBucket.tat = tat
+	}
+}
+
+func (l *limiter) botBucket(bot string) *bucket {
+	botBucket, found := l.bots[bot]
+	if !found {
+		botBucket = &bucket{rate: l.limits.Bot}
+		l.bots[bot] = botBucket
+	}
+	return botBucket
+}
+
+func (l *limiter) chatBucket(bot string, chatID int64, now time.Time) *bucket {
+	key := chatKey{bot: bot, chat: chatID}
+	chatBucket, found := l.chats[key]
+	if !found {
+		if len(l.chats) >= maxBuckets {
+			l.prune(now)
+		}
+		rate := l.limits.Private
+		if chatID < 0 { // groups, supergroups and channels
+			rate = l.limits.Group
+		}
+		chatBucket = &bucket{rate: rate}
+		l.chats[key] = chatBucket
+	}
+	return chatBucket
+}
+
+// prune drops buckets that are fully drained and behave like new ones.
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.chats {
+		if !b.tat.After(now) {
+			delete(l.chats, key)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when the limiter sleeps.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.now = c.now.Add(d)
+	return nil
+}
+
+var testLimits = Limits{
+	Bot:     Rate{Interval: 100 * time.Millisecond, Burst: 4},
+	Private: Rate{Interval: time.Second, Burst: 2},
+	Group:   Rate{Interval: 3 * time.Second, Burst: 2},
+}
+
+func newTestLimiter() (*limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return newLimiter(clock, testLimits), clock
+}
+
+// waits sends to the chats in order and returns how long each send waited.
+func waits(l *limiter, clock *fakeClock, bot string, chats ...int64) []time.Duration {
+	result := make([]time.Duration, len(chats))
+	for i, chatID := range chats {
+		start := clock.now
+		l.wait(context.Background(), bot, chatID)
+		result[i] = clock.now.Sub(start)
+	}
+	return result
+}
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		name  string
+		chats []int64
+		want  []time.Duration
+	}{
+		{
+			name:  "private chat bursts then sends once per interval",
+			chats: []int64{1, 1, 1, 1},
+			want:  []time.Duration{0, 0, time.Second, time.Second},
+		},
+		{
+			name:  "group chat uses the group interval",
+			chats: []int64{-1, -1, -1},
+			want:  []time.Duration{0, 0, 3 * time.Second},
+		},
+		{
+			name:  "bot limit spans its chats",
+			chats: []int64{1, 2, 3, 4, 5, 6},
+			want:  []time.Duration{0, 0, 0, 0, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter()
+			got := waits(l, clock, "bot", tt.chats...)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("waits = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterBotsAreIndependent(t *testing.T) {
+	l, clock := newTestLimiter()
+	waits(l, clock, "a", 1, 2, 3, 4)
+	if got := waits(l, clock, "b", 1); got[0] != 0 {
+		t.Fatalf("bot b waited %v for bot a", got[0])
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	l, clock := newTestLimiter()
+	l.backoff("bot", 1, 10*time.Second)
+
+	if got := waits(l, clock, "bot", 1); got[0] != 10*time.Second {
+		t.Fatalf("chat in backoff waited %v, want 10s", got[0])
+	}
+	if got := waits(l, clock, "bot", 1); got[0] != time.Second {
+		t.Fatalf("after backoff waited %v, want the private interval", got[0])
+	}
+}
+
+// A chat waiting for its slot must not book the bot slot ahead of time and
+// hold up the other chats of the bot.
+func TestLimiterNoHeadOfLineBlocking(t *testing.T) {
+	tests := []struct {
+		name string
+		// block makes a chat of the bot wait and returns the wait it got
+		block func(l *limiter) time.Duration
+	}{
+		{
+			name: "backoff",
+			block: func(l *limiter) time.Duration {
+				l.backoff("bot", 1, 10*time.Second)
+				return l.reserveChat("bot", 1)
+			},
+		},
+		{
+			name: "group interval",
+			block: func(l *limiter) time.Duration {
+				l.reserveChat("bot", -1)
+				l.reserveChat("bot", -1)
+				return l.reserveChat("bot", -1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter()
+			if d := tt.block(l); d == 0 {
+				t.Fatal("blocked chat got a slot right away")
+			}
+			if got := waits(l, clock, "bot", 2); got[0] != 0 {
+				t.Fatalf("other chat waited %v", got[0])
+			}
+		})
+	}
+}
+
+func TestLimiterWaitIsCanceled(t *testing.T) {
+	l := newLimiter(realClock{}, testLimits)
+	for i := 0; i < testLimits.Private.Burst; i++ {
+		if err := l.wait(context.Background(), "token", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if err := l.wait(ctx, "token", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed >= testLimits.Private.Interval/2 {
+		t.Fatalf("canceled wait took %v", elapsed)
+	}
+}
@@ -1,84 +1,212 @@
 package sender
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync"
 	"telegram-listener/database"
 	"telegram-listener/helper"
+	"telegram-listener/metrics"
+	"time"
 
 	"gopkg.in/telebot.v4"
 )
 
+// maxFloodRetries is how many times a send is repeated after a 429.
+const maxFloodRetries = 3
+
+var ErrShutdown = errors.New("sender is shutting down")
+
 type Service struct {
-	// mu        sync.RWMutex
+	ctx       context.Context // of the app, cuts the waits for a send slot on shutdown
+	mu        sync.RWMutex
 	dbService *database.Service
+	limiter   *limiter
+	closed    bool
+	pending   sync.WaitGroup
+	blocked   *blockedSet
 }
 
-func NewService(dbService *database.Service) (s *Service, err error) {
+func NewService(ctx context.Context, dbService *database.Service) (s *Service, err error) {
 	s = &Service{
+		ctx:       ctx,
 		dbService: dbService,
+		limiter:   newLimiter(realClock{}, DefaultLimits),
+		blocked:   newBlockedSet(realClock{}),
 	}
 	return
 }
 
-func (s *Service) SendText(tbot *telebot.Bot, chatID int64, msg string, inlineMenuJson string, replyMenuJson string) (err error) {
+// Shutdown rejects new sends and waits for the ones in flight. Sends still
+// waiting for a slot give up once the context of the app is done.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send waits for a free slot of the bot and chat and retries on flood errors.
+func (s *Service) send(tbot *telebot.Bot, chatID int64, what interface{}, opts ...interface{}) (err error) {
 	recipient := &telebot.User{ID: chatID}
+	return s.do(tbot, chatID, sendMethod(what), func() error {
+		_, err := tbot.Send(recipient, what, opts...)
+		return err
+	})
+}
 
-	msg = helper.SanitizeTelegramHTML(msg)
-	if inlineMenuJson != "" {
-		inlineMenu, err := s.createInlineMenu(inlineMenuJson)
-		if err != nil {
-			log.Println("Failed to create inline menu:", err)
-		} else {
-			_, err = tbot.Send(recipient, msg, inlineMenu, telebot.ModeHTML)
-			return err
+// do runs one API call to the chat through the limiter.
+func (s *Service) do(tbot *telebot.Bot, chatID int64, method string, call func() error) (err error) {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrShutdown
+	}
+	s.pending.Add(1)
+	s.mu.RUnlock()
+	defer s.pending.Done()
+
+	if s.isBlocked(tbot, chatID) {
+		metrics.Sends.WithLabelValues(tbot.Me.Username, method, "blocked").Inc()
+		return ErrBlocked
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = s.limiter.wait(s.ctx, tbot.Token, chatID); err != nil {
+			metrics.Sends.WithLabelValues(tbot.Me.Username, method, "canceled").Inc()
+			return
+		}
+		err = call()
+
+		var floodErr telebot.FloodError
+		if !errors.As(err, &floodErr) || attempt >= maxFloodRetries {
+			result := "ok"
+			if err != nil {
+				result = "error"
+			}
+			if reason := blockedReason(err); reason != "" {
+				s.disable(tbot, chatID, reason)
+			}
+			metrics.Sends.WithLabelValues(tbot.Me.Username, method, result).Inc()
+			return
 		}
+		metrics.FloodWaits.WithLabelValues(tbot.Me.Username).Inc()
+		metrics.FloodWaitSeconds.WithLabelValues(tbot.Me.Username).Add(float64(floodErr.RetryAfter))
+		retryAfter := time.Duration(floodErr.RetryAfter) * time.Second
+		log.Printf("flood wait %s for chat %d, attempt %d", retryAfter, chatID, attempt+1)
+		s.limiter.backoff(tbot.Token, chatID, retryAfter)
 	}
-	if replyMenuJson != "" {
-		replyMenu, err := s.createReplyMenu(replyMenuJson)
-		if err != nil {
-			log.Println("Failed to create reply menu:", err)
-		} else {
-			_, err = tbot.Send(recipient, msg, replyMenu, telebot.ModeHTML)
-			return err
+}
+
+func sendMethod(what interface{}) string {
+	switch what.(type) {
+	case *telebot.Photo:
+		return "photo"
+	case *telebot.Video:
+		return "video"
+	default:
+		return "text"
+	}
+}
+
+// place puts the options of where the message goes (reply, forum topic)
+// before the menu and parse mode, which telebot applies on top of them.
+func place(where []*telebot.SendOptions, opts ...interface{}) []interface{} {
+	placed := make([]interface{}, 0, len(where)+len(opts))
+	for _, w := range where {
+		if w != nil {
+			placed = append(placed, w)
 		}
 	}
+	return append(placed, opts...)
+}
 
-	_, err = tbot.Send(recipient, msg, telebot.ModeHTML)
-	return
+// SendText sends msg with one of the menus. where optionally places the
+// message as a reply or into a forum topic of a group.
+func (s *Service) SendText(tbot *telebot.Bot, chatID int64, msg string, inlineMenuJson string, replyMenuJson string, where ...*telebot.SendOptions) (err error) {
+	msg = helper.SanitizeTelegramHTML(msg)
+	return s.send(tbot, chatID, msg, place(where, s.menu(inlineMenuJson, replyMenuJson)...)...)
 }
 
-func (s *Service) SendPhoto(tbot *telebot.Bot, inlineMenuJson string, chatID int64, msg string, url string) (err error) {
-	recipient := &telebot.User{ID: chatID}
+func (s *Service) SendPhoto(tbot *telebot.Bot, inlineMenuJson string, replyMenuJson string, chatID int64, msg string, url string, where ...*telebot.SendOptions) (err error) {
 	photo := &telebot.Photo{
 		File:    telebot.FromURL(url),
 		Caption: helper.SanitizeTelegramHTML(msg),
 	}
+	return s.send(tbot, chatID, photo, place(where, s.menu(inlineMenuJson, replyMenuJson)...)...)
+}
 
+func (s *Service) SendVideo(tbot *telebot.Bot, inlineMenuJson string, replyMenuJson string, chatID int64, msg string, url string, where ...*telebot.SendOptions) (err error) {
+	video := &telebot.Video{
+		File:    telebot.FromURL(url),
+		Caption: helper.SanitizeTelegramHTML(msg),
+	}
+	return s.send(tbot, chatID, video, place(where, s.menu(inlineMenuJson, replyMenuJson)...)...)
+}
+
+// menu is the parse mode with the menu of a message. A message takes one
+// markup, the inline menu wins over the reply keyboard.
+func (s *Service) menu(inlineMenuJson string, replyMenuJson string) []interface{} {
 	if inlineMenuJson != "" {
 		inlineMenu, err := s.createInlineMenu(inlineMenuJson)
 		if err == nil {
-			_, err = tbot.Send(recipient, photo, inlineMenu, telebot.ModeHTML)
-			return err
+			return []interface{}{inlineMenu, telebot.ModeHTML}
 		}
+		log.Println("Failed to create inline menu:", err)
 	}
-	_, err = tbot.Send(recipient, photo, telebot.ModeHTML)
-	return
+	if replyMenuJson != "" {
+		replyMenu, err := s.createReplyMenu(replyMenuJson)
+		if err == nil {
+			return []interface{}{replyMenu, telebot.ModeHTML}
+		}
+		log.Println("Failed to create reply menu:", err)
+	}
+	return []interface{}{telebot.ModeHTML}
 }
 
-func (s *Service) SendVideo(tbot *telebot.Bot, inlineMenuJson string, chatID int64, msg string, url string) (err error) {
-	recipient := &telebot.User{ID: chatID}
-	video := &telebot.Video{
-		File:    telebot.FromURL(url),
-		Caption: helper.SanitizeTelegramHTML(msg),
+// Notify shows a chat action like "typing" in the chat for up to 5 seconds.
+func (s *Service) Notify(tbot *telebot.Bot, chatID int64, action telebot.ChatAction, where ...*telebot.SendOptions) (err error) {
+	threadID := []int{}
+	for _, w := range where {
+		if w != nil && w.ThreadID != 0 {
+			threadID = append(threadID, w.ThreadID)
+		}
 	}
+	return s.do(tbot, chatID, "action", func() error {
+		return tbot.Notify(&telebot.User{ID: chatID}, action, threadID...)
+	})
+}
 
+// EditText replaces text and inline menu of a sent message in place.
+func (s *Service) EditText(tbot *telebot.Bot, chatID int64, message telebot.Editable, msg string, inlineMenuJson string) (err error) {
+	msg = helper.SanitizeTelegramHTML(msg)
+	opts := []interface{}{telebot.ModeHTML}
 	if inlineMenuJson != "" {
 		inlineMenu, err := s.createInlineMenu(inlineMenuJson)
-		if err == nil {
-			_, err = tbot.Send(recipient, video, inlineMenu, telebot.ModeHTML)
-			return err
+		if err != nil {
+			log.Println("Failed to create inline menu:", err)
+		} else {
+			opts = append(opts, inlineMenu)
 		}
 	}
-	_, err = tbot.Send(recipient, video, telebot.ModeHTML)
-	return
+	return s.do(tbot, chatID, "edit", func() error {
+		_, err := tbot.Edit(message, msg, opts...)
+		if errors.Is(err, telebot.ErrSameMessageContent) || errors.Is(err, telebot.ErrMessageNotModified) {
+			return nil // double click on the same button
+		}
+		return err
+	})
 }
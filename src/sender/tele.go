@@ -1,17 +1,58 @@
 package sender
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 
 	"gopkg.in/telebot.v4"
 )
 
-type InlineMenu []struct {
-	Row []struct {
-		Title string `json:"title"`
-		Value string `json:"value"`
-	} `json:"row"`
+// Inline button types, an empty type is a URL button.
+const (
+	ButtonURL               = "url"
+	ButtonCallback          = "callback"
+	ButtonSwitchInlineQuery = "switch_inline_query"
+	ButtonWebApp            = "web_app"
+	ButtonCopyText          = "copy_text"
+)
+
+// MaxCallbackData is the Telegram limit for callback_data in bytes.
+const MaxCallbackData = 64
+
+type InlineButton struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+type InlineMenuRow struct {
+	Row []InlineButton `json:"row"`
+}
+
+type InlineMenu []InlineMenuRow
+
+func (btn InlineButton) button(inline *telebot.ReplyMarkup) (telebot.Btn, error) {
+	if btn.Value == "" && btn.Type != ButtonSwitchInlineQuery { // empty query just opens the chat picker
+		return telebot.Btn{}, errors.New("empty value for button: " + btn.Title)
+	}
+	switch btn.Type {
+	case "", ButtonURL:
+		return inline.URL(btn.Title, btn.Value), nil
+	case ButtonCallback:
+		if len(btn.Value) > MaxCallbackData {
+			return telebot.Btn{}, errors.New("callback data is longer than 64 bytes for button: " + btn.Title)
+		}
+		return telebot.Btn{Text: btn.Title, Data: btn.Value}, nil
+	case ButtonSwitchInlineQuery:
+		return inline.Query(btn.Title, btn.Value), nil
+	case ButtonWebApp:
+		return inline.WebApp(btn.Title, &telebot.WebApp{URL: btn.Value}), nil
+	case ButtonCopyText:
+		return inline.CopyText(btn.Title, btn.Value), nil
+	default:
+		return telebot.Btn{}, errors.New("unknown type " + btn.Type + " for button: " + btn.Title)
+	}
 }
 
 func (s *Service) createInlineMenu(inlineMenuJson string) (inline *telebot.ReplyMarkup, err error) {
@@ -31,10 +72,11 @@ func (s *Service) createInlineMenu(inlineMenuJson string) (inline *telebot.Reply
 	for _, row := range inlineMenu {
 		var rowButtons []telebot.Btn
 		for _, btn := range row.Row {
-			if btn.Value == "" {
-				return nil, errors.New("empty value for button: " + btn.Title)
+			button, err := btn.button(inline)
+			if err != nil {
+				return nil, err
 			}
-			rowButtons = append(rowButtons, inline.URL(btn.Title, btn.Value))
+			rowButtons = append(rowButtons, button)
 		}
 		rows = append(rows, inline.Row(rowButtons...))
 	}
@@ -43,39 +85,109 @@ func (s *Service) createInlineMenu(inlineMenuJson string) (inline *telebot.Reply
 	return
 }
 
-type ReplyMenu []struct {
-	Row []struct {
-		Title string `json:"title"`
-		Value string `json:"value"`
-	} `json:"row"`
+// Reply keyboard button types, an empty type is a text button.
+const (
+	ButtonText            = "text"
+	ButtonRequestContact  = "request_contact"
+	ButtonRequestLocation = "request_location"
+	ButtonRequestPoll     = "request_poll"
+)
+
+// ReplyButton sends its title as a message. Value optionally names the
+// reaction handle the title routes to; for request_poll it is the poll type
+// (quiz or regular).
+type ReplyButton struct {
+	Title string `json:"title"`
+	Value string `json:"value,omitempty"`
+	Type  string `json:"type,omitempty"`
 }
 
-func (s *Service) createReplyMenu(replyMenuJson string) (inline *telebot.ReplyMarkup, err error) {
-	inline = &telebot.ReplyMarkup{}
+type ReplyMenuRow struct {
+	Row []ReplyButton `json:"row"`
+}
+
+// ReplyMenu is either a bare array of rows or an object with keyboard
+// options. {"remove": true} removes the keyboard shown before.
+type ReplyMenu struct {
+	Rows        []ReplyMenuRow `json:"rows"`
+	Resize      bool           `json:"resize_keyboard"`
+	OneTime     bool           `json:"one_time_keyboard"`
+	Placeholder string         `json:"input_field_placeholder"`
+	Selective   bool           `json:"selective"`
+	Persistent  bool           `json:"is_persistent"`
+	Remove      bool           `json:"remove"`
+}
+
+func (m *ReplyMenu) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(data, &m.Rows)
+	}
+	type replyMenu ReplyMenu // no UnmarshalJSON, no recursion
+	return json.Unmarshal(data, (*replyMenu)(m))
+}
+
+func ParseReplyMenu(replyMenuJson string) (replyMenu ReplyMenu, err error) {
+	err = json.Unmarshal([]byte(replyMenuJson), &replyMenu)
+	return
+}
+
+func (btn ReplyButton) button(markup *telebot.ReplyMarkup) (telebot.Btn, error) {
+	if btn.Title == "" {
+		return telebot.Btn{}, errors.New("empty title for reply button")
+	}
+	switch btn.Type {
+	case "", ButtonText:
+		return markup.Text(btn.Title), nil
+	case ButtonRequestContact:
+		return markup.Contact(btn.Title), nil
+	case ButtonRequestLocation:
+		return markup.Location(btn.Title), nil
+	case ButtonRequestPoll:
+		return markup.Poll(btn.Title, telebot.PollType(btn.Value)), nil
+	default:
+		return telebot.Btn{}, errors.New("unknown type " + btn.Type + " for button: " + btn.Title)
+	}
+}
+
+func (s *Service) createReplyMenu(replyMenuJson string) (markup *telebot.ReplyMarkup, err error) {
+	markup = &telebot.ReplyMarkup{}
 	if replyMenuJson == "" {
 		return nil, errors.New("empty js for reply menu")
 	}
 
-	replyMenu := ReplyMenu{}
-
-	err = json.Unmarshal([]byte(replyMenuJson), &replyMenu)
+	replyMenu, err := ParseReplyMenu(replyMenuJson)
 	if err != nil {
 		return nil, err
 	}
 
+	if replyMenu.Remove {
+		markup.RemoveKeyboard = true
+		markup.Selective = replyMenu.Selective
+		return
+	}
+
 	rows := []telebot.Row{}
-	for _, row := range replyMenu {
+	for _, row := range replyMenu.Rows {
 		var rowButtons []telebot.Btn
 		for _, btn := range row.Row {
-			if btn.Value == "" {
-				return nil, errors.New("empty value for button: " + btn.Title)
+			button, err := btn.button(markup)
+			if err != nil {
+				return nil, err
 			}
-			rowButtons = append(rowButtons, inline.URL(btn.Title, btn.Value))
+			rowButtons = append(rowButtons, button)
 		}
-		rows = append(rows, inline.Row(rowButtons...))
+		rows = append(rows, markup.Row(rowButtons...))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("reply menu without buttons")
 	}
 
-	inline.Reply(rows...)
+	markup.Reply(rows...)
+	markup.ResizeKeyboard = replyMenu.Resize
+	markup.OneTimeKeyboard = replyMenu.OneTime
+	markup.Placeholder = replyMenu.Placeholder
+	markup.Selective = replyMenu.Selective
+	markup.IsPersistent = replyMenu.Persistent
 
 	return
 }
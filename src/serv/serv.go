@@ -1,35 +1,115 @@
 package serv
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"telegram-listener/metrics"
 )
 
+// SecretTokenHeader is the header Telegram sets on every webhook request
+// when a secret_token was passed to setWebhook.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type webhook struct {
+	secret  string
+	handler http.Handler
+}
+
 type Service struct {
-	mux  *http.ServeMux
-	port string
+	mu       sync.RWMutex
+	mux      *http.ServeMux
+	server   *http.Server
+	port     string
+	webhooks map[int]webhook
 }
 
-func (s *Service) Run() {
+// Run serves until Shutdown is called.
+func (s *Service) Run() error {
 	log.Println("Start http server on :" + s.port)
-	http.HandleFunc("/incoming", func(w http.ResponseWriter, req *http.Request) {
+	s.mux.HandleFunc("POST /incoming/{botID}", s.incoming)
 
-	})
+	s.mux.Handle("GET /metrics", metrics.Handler())
 
-	http.HandleFunc("/alive", func(w http.ResponseWriter, req *http.Request) {
+	s.mux.HandleFunc("/alive", func(w http.ResponseWriter, req *http.Request) {
 		_, _ = fmt.Fprintf(w, "OK")
 	})
 
-	err := http.ListenAndServe(":"+s.port, nil)
+	err := s.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Service) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Handle adds an endpoint, other services use it to expose their own pages.
+func (s *Service) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// RegisterWebhook routes POST /incoming/{botID} to handler. Requests without
+// the matching secret token are rejected before reaching the handler.
+func (s *Service) RegisterWebhook(botID int, secret string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[botID] = webhook{
+		secret:  secret,
+		handler: handler,
+	}
+}
+
+// UnregisterWebhook removes the route of the bot if it still goes to handler,
+// a newer handler registered for the same bot keeps it.
+func (s *Service) UnregisterWebhook(botID int, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hook, found := s.webhooks[botID]; found && hook.handler == handler {
+		delete(s.webhooks, botID)
+	}
+}
+
+func (s *Service) incoming(w http.ResponseWriter, req *http.Request) {
+	botID, err := strconv.Atoi(req.PathValue("botID"))
 	if err != nil {
-		panic(err)
+		http.NotFound(w, req)
+		return
 	}
+
+	s.mu.RLock()
+	hook, found := s.webhooks[botID]
+	s.mu.RUnlock()
+	if !found {
+		http.NotFound(w, req)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get(SecretTokenHeader)), []byte(hook.secret)) != 1 {
+		log.Println("webhook: wrong secret token for bot", botID, "from", req.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	hook.handler.ServeHTTP(w, req)
 }
 
 func NewService(port string) (*Service, error) {
 	s := &Service{
-		port: port,
+		mux:      http.NewServeMux(),
+		port:     port,
+		webhooks: make(map[int]webhook),
+	}
+	s.server = &http.Server{
+		Addr:    ":" + port,
+		Handler: s.mux,
 	}
 	return s, nil
 }
@@ -1,10 +1,13 @@
 package settings
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"telegram-listener/database"
+	"telegram-listener/metrics"
 	"time"
 )
 
@@ -15,7 +18,7 @@ type Service struct {
 	settings     []*database.Setting
 }
 
-func NewService(dbService *database.Service, updatePeriod int) (s *Service, err error) {
+func NewService(ctx context.Context, dbService *database.Service, updatePeriod int) (s *Service, err error) {
 
 	s = &Service{
 		dbService:    dbService,
@@ -24,32 +27,73 @@ func NewService(dbService *database.Service, updatePeriod int) (s *Service, err
 
 	err = s.loadData()
 
-	go s.loadWorker()
+	go s.loadWorker(ctx)
 
 	return
 }
 
-func (s *Service) loadWorker() {
+func (s *Service) loadWorker(ctx context.Context) {
 	for {
-		time.Sleep(time.Second * s.updatePeriod)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * s.updatePeriod):
+		}
 		if err := s.loadData(); err != nil {
 			log.Println(err)
 		}
 	}
 }
 
-func (s *Service) GetOne(botID int, command, part string) (setting *database.Setting, err error) {
+// GetOne returns the setting in the first of locales that has it, the one
+// without locale when none has.
+func (s *Service) GetOne(botID int, command, part string, locales ...string) (setting *database.Setting, err error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, s := range s.settings {
-		if s.BotID == botID && s.Command == command && s.Part == part {
-			return s, nil
+	for _, locale := range append(locales, "") {
+		for _, s := range s.settings {
+			if s.BotID == botID && s.Command == command && s.Part == part && strings.EqualFold(s.Locale, locale) {
+				return s, nil
+			}
 		}
 	}
 	return nil, fmt.Errorf("setting not found")
 }
 
+// Locales lists the locales the bot has settings in.
+func (s *Service) Locales(botID int) (locales []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{}
+	for _, setting := range s.settings {
+		locale := strings.ToLower(setting.Locale)
+		if setting.BotID == botID && locale != "" && !seen[locale] {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+	return
+}
+
+// Content returns a lookup of the content of the bot settings, empty for
+// missing ones, for templates.
+func (s *Service) Content(botID int, locales ...string) func(command, part string) string {
+	return func(command, part string) string {
+		setting, err := s.GetOne(botID, command, part, locales...)
+		if err != nil {
+			return ""
+		}
+		return setting.Content
+	}
+}
+
+// Reload reads settings from the DB right away.
+func (s *Service) Reload() error {
+	return s.loadData()
+}
+
 func (s *Service) loadData() (err error) {
+	defer func(start time.Time) { metrics.ObserveReload("settings", start, err) }(time.Now())
 	settings, err := database.GetAllSettings(s.dbService)
 	if err != nil {
 		return
@@ -0,0 +1,137 @@
+// Package tmpl renders answers, captions and pushes. Texts are html/template
+// templates, so values coming from users are HTML-escaped:
+//
+//	Привет, {{default "друг" .User.FirstName}}! {{if .User.Counter}}С возвращением.{{end}}
+//	{{.Bot.Name}} {{.Bot.AppURL}} {{date "02.01.2006"}} {{setting "search" "not_found"}}
+//
+// The old placeholders [orig-message], [user-username], [state:<name>],
+// [title], [year] and [url] still work.
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type User struct {
+	ID        int64
+	Username  string
+	FirstName string
+	LastName  string
+	Language  string
+
+	// Stats returns the stored counters of the user, called only by the
+	// templates using Counter or LastCommand. Nil renders zero values.
+	Stats func() UserStats
+}
+
+// UserStats are as of the last write of the user, the update being answered
+// is not counted yet.
+type UserStats struct {
+	Counter     int
+	LastCommand string
+}
+
+func (u User) Counter() int {
+	if u.Stats == nil {
+		return 0
+	}
+	return u.Stats().Counter
+}
+
+func (u User) LastCommand() string {
+	if u.Stats == nil {
+		return ""
+	}
+	return u.Stats().LastCommand
+}
+
+type Bot struct {
+	ID     int
+	Name   string // telegram username
+	AppURL string
+}
+
+// Post is the search result of a card or an inline result.
+type Post struct {
+	Title string
+	Year  string
+	URL   string
+}
+
+type Data struct {
+	Message string // text the user sent
+	User    User
+	Bot     Bot
+	Post    Post
+	State   map[string]string // answers of the conversation, see reaction/state.go
+	Now     time.Time
+
+	// Setting returns the content of a bot setting, nil renders empty strings.
+	Setting func(command, part string) string
+}
+
+var legacy = strings.NewReplacer(
+	"[orig-message]", "{{.Message}}",
+	"[user-username]", "{{.User.Username}}",
+	"[title]", "{{.Post.Title}}",
+	"[year]", "{{.Post.Year}}",
+	"[url]", "{{.Post.URL}}",
+)
+
+var legacyState = regexp.MustCompile(`\[state:([^\]\s]+)\]`)
+
+func translate(text string) string {
+	text = legacy.Replace(text)
+	return legacyState.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := legacyState.FindStringSubmatch(placeholder)[1]
+		return fmt.Sprintf("{{index .State %q}}", name)
+	})
+}
+
+func (data Data) funcs() template.FuncMap {
+	now := data.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return template.FuncMap{
+		"setting": func(command, part string) string {
+			if data.Setting == nil {
+				return ""
+			}
+			return data.Setting(command, part)
+		},
+		"date": func(layout string) string {
+			return now.Format(layout)
+		},
+		"default": func(def string, value string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		},
+	}
+}
+
+// Render executes text with data. A broken template is logged and sent as is.
+func Render(text string, data Data) string {
+	if !strings.Contains(text, "[") && !strings.Contains(text, "{{") {
+		return text
+	}
+	t, err := template.New("answer").Funcs(data.funcs()).Parse(translate(text))
+	if err != nil {
+		log.Printf("Failed to parse template %q: %v", text, err)
+		return text
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		log.Printf("Failed to render template %q: %v", text, err)
+		return text
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package users
+
+import (
+	"context"
+	"log"
+	"sync"
+	"telegram-listener/batching"
+	"telegram-listener/database"
+	"telegram-listener/metrics"
+	"time"
+)
+
+const (
+	batchSize   = 500    // users in one INSERT
+	maxPending  = 100000 // new users are dropped beyond it, queued ones still merge
+	flushPeriod = 2 * time.Second
+)
+
+type key struct {
+	botID int
+	tgID  int64
+}
+
+type pending struct {
+	user    *database.TelegramUser
+	private bool // wrote in private since the last write, enables the user again
+}
+
+// Service is a write-behind queue of user upserts. Updates of the same user
+// are merged until the writer stores them, every flushPeriod or as soon as
+// batchSize users are waiting, so handlers never wait for MySQL.
+type Service struct {
+	dbService *database.Service
+
+	mu      sync.Mutex
+	pending map[key]*pending
+	loop    *batching.Loop
+}
+
+func NewService(dbService *database.Service) (s *Service, err error) {
+	s = &Service{
+		dbService: dbService,
+		pending:   make(map[key]*pending),
+	}
+	// failed batches are queued again, except in the last flush
+	s.loop = batching.NewLoop(flushPeriod, func(final bool) { s.flush(!final) })
+
+	return
+}
+
+// Upsert queues one update of the user: Counter goes up by one, the profile,
+// last command, chat type and activity time are the ones of the latest update.
+func (s *Service) Upsert(user database.TelegramUser) {
+	now := time.Now().UTC()
+	user.LastActivityAt = &now
+
+	private := user.ChatType == database.ChatTypePrivate
+	s.mu.Lock()
+	if s.loop.Closed() {
+		s.mu.Unlock()
+		// the writer is gone, late updates of stopping handlers go straight to the DB
+		user.Counter = 1
+		s.write([]*pending{{user: &user, private: private}}, private, false)
+		return
+	}
+	defer s.mu.Unlock()
+	if !s.merge(&pending{user: &user, private: private}, 1) {
+		metrics.UserUpserts.WithLabelValues("dropped").Inc()
+		return
+	}
+	if len(s.pending) >= batchSize {
+		s.loop.Kick()
+	}
+}
+
+// merge adds count updates of p to the queue, false when the queue is full.
+// The caller holds s.mu.
+func (s *Service) merge(p *pending, count int) bool {
+	k := key{botID: p.user.BotID, tgID: p.user.TgID}
+	queued, found := s.pending[k]
+	if !found {
+		if len(s.pending) >= maxPending {
+			return false
+		}
+		p.user.Counter = count
+		s.pending[k] = p
+		metrics.UserQueue.Set(float64(len(s.pending)))
+		return true
+	}
+
+	newer, older := p.user, queued.user
+	if older.LastActivityAt.After(*newer.LastActivityAt) {
+		newer, older = older, newer // a failed batch coming back is older than the queued update
+	}
+	user := *newer
+	user.Counter = queued.user.Counter + count
+	if user.ChatType == "" {
+		user.ChatType = older.ChatType
+	}
+	queued.user = &user
+	queued.private = queued.private || p.private
+	return true
+}
+
+// Shutdown stores the queued users.
+func (s *Service) Shutdown(ctx context.Context) error {
+	return s.loop.Shutdown(ctx)
+}
+
+// flush writes the queued users in batches, those of failed batches are queued
+// again when retry is set.
+func (s *Service) flush(retry bool) {
+	s.mu.Lock()
+	queued := s.pending
+	s.pending = make(map[key]*pending)
+	metrics.UserQueue.Set(0)
+	s.mu.Unlock()
+	if len(queued) == 0 {
+		return
+	}
+
+	// users that wrote in private are enabled again, they go in their own batches
+	batches := map[bool][]*pending{}
+	for _, p := range queued {
+		batches[p.private] = append(batches[p.private], p)
+	}
+	for private, all := range batches {
+		for start := 0; start < len(all); start += batchSize {
+			batch := all[start:min(start+batchSize, len(all))]
+			s.write(batch, private, retry)
+		}
+	}
+}
+
+func (s *Service) write(batch []*pending, private bool, retry bool) {
+	users := make([]*database.TelegramUser, len(batch))
+	for i, p := range batch {
+		users[i] = p.user
+	}
+	err := database.UpsertUsers(s.dbService, users, private)
+	if err == nil {
+		metrics.UserUpserts.WithLabelValues("ok").Add(float64(len(users)))
+		return
+	}
+
+	log.Printf("❌ Failed to save %d users: %v", len(users), err)
+	metrics.UserUpserts.WithLabelValues("error").Add(float64(len(users)))
+	if !retry {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range batch {
+		if !s.merge(p, p.user.Counter) {
+			metrics.UserUpserts.WithLabelValues("dropped").Inc()
+		}
+	}
+	metrics.UserQueue.Set(float64(len(s.pending)))
+}